@@ -0,0 +1,129 @@
+// Package cache ではプロセス内のキャッシュが実装されています。
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// Stats は LRU の利用状況を表す。
+type Stats struct {
+	Hits    int64
+	Misses  int64
+	Entries int
+	Bytes   int64
+}
+
+type entry struct {
+	key   string
+	value interface{}
+	size  int64
+}
+
+// LRU はエントリー数とバイト数で上限を設けた Least Recently Used キャッシュ。
+// 複数の goroutine から同時に使用できる。
+type LRU struct {
+	hits       int64
+	misses     int64
+	maxEntries int
+	maxBytes   int64
+
+	mu    sync.Mutex
+	bytes int64
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+// New は LRU を作成する。
+// maxEntries か maxBytes が 0 以下の場合、その上限は設けない。
+func New(maxEntries int, maxBytes int64) *LRU {
+	return &LRU{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// Get はキー key の値を返す。
+func (c *LRU) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		atomic.AddInt64(&c.hits, 1)
+		return e.Value.(*entry).value, true
+	}
+	atomic.AddInt64(&c.misses, 1)
+	return nil, false
+}
+
+// Add はキー key で値 value を追加する。size は値のおおよそのバイト数。
+// 上限を超えた場合は最も長く使われていないエントリーから破棄する。
+// size が上限のバイト数を超える場合は追加しない。
+func (c *LRU) Add(key string, value interface{}, size int64) {
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		en := e.Value.(*entry)
+		c.bytes += size - en.size
+		en.value = value
+		en.size = size
+	} else {
+		c.items[key] = c.ll.PushFront(&entry{key, value, size})
+		c.bytes += size
+	}
+	for (c.maxEntries > 0 && c.ll.Len() > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+// Remove はキー key のエントリーを破棄する。
+func (c *LRU) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.removeElement(e)
+	}
+}
+
+// RemoveFunc は f が true を返すエントリーを全て破棄し、破棄した数を返す。
+func (c *LRU) RemoveFunc(f func(key string, value interface{}) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for e := c.ll.Front(); e != nil; {
+		next := e.Next()
+		en := e.Value.(*entry)
+		if f(en.key, en.value) {
+			c.removeElement(e)
+			n++
+		}
+		e = next
+	}
+	return n
+}
+
+// Stats は利用状況を返す。
+func (c *LRU) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{
+		Hits:    atomic.LoadInt64(&c.hits),
+		Misses:  atomic.LoadInt64(&c.misses),
+		Entries: c.ll.Len(),
+		Bytes:   c.bytes,
+	}
+}
+
+func (c *LRU) removeElement(e *list.Element) {
+	c.ll.Remove(e)
+	en := e.Value.(*entry)
+	delete(c.items, en.key)
+	c.bytes -= en.size
+}
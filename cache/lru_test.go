@@ -0,0 +1,109 @@
+package cache_test
+
+import (
+	"testing"
+
+	"github.com/minodisk/resizer/cache"
+)
+
+func TestLRU(t *testing.T) {
+	t.Parallel()
+
+	for _, c := range []struct {
+		name       string
+		maxEntries int
+		maxBytes   int64
+		adds       []string
+		sizes      []int64
+		gets       []string
+		want       []string
+		missing    []string
+	}{
+		{
+			"evict by entries",
+			2,
+			0,
+			[]string{"a", "b", "c"},
+			[]int64{1, 1, 1},
+			nil,
+			[]string{"b", "c"},
+			[]string{"a"},
+		},
+		{
+			"evict by bytes",
+			0,
+			10,
+			[]string{"a", "b", "c"},
+			[]int64{4, 4, 4},
+			nil,
+			[]string{"b", "c"},
+			[]string{"a"},
+		},
+		{
+			"evict least recently used",
+			2,
+			0,
+			[]string{"a", "b", "c"},
+			[]int64{1, 1, 1},
+			[]string{"a"},
+			[]string{"a", "c"},
+			[]string{"b"},
+		},
+		{
+			"ignore too large value",
+			0,
+			10,
+			[]string{"a", "b"},
+			[]int64{4, 11},
+			nil,
+			[]string{"a"},
+			[]string{"b"},
+		},
+	} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			l := cache.New(c.maxEntries, c.maxBytes)
+			for i, k := range c.adds {
+				l.Add(k, k, c.sizes[i])
+				// 3番目を追加する直前に gets のキーを参照する
+				if i == 1 {
+					for _, g := range c.gets {
+						l.Get(g)
+					}
+				}
+			}
+			for _, k := range c.want {
+				if v, ok := l.Get(k); !ok || v != k {
+					t.Errorf("%s should be cached", k)
+				}
+			}
+			for _, k := range c.missing {
+				if _, ok := l.Get(k); ok {
+					t.Errorf("%s shouldn't be cached", k)
+				}
+			}
+		})
+	}
+}
+
+func TestLRURemove(t *testing.T) {
+	t.Parallel()
+
+	l := cache.New(0, 0)
+	l.Add("foo:1", 1, 1)
+	l.Add("foo:2", 2, 1)
+	l.Add("bar:1", 3, 1)
+	l.Remove("foo:1")
+	if _, ok := l.Get("foo:1"); ok {
+		t.Errorf("foo:1 should be removed")
+	}
+	if n := l.RemoveFunc(func(key string, value interface{}) bool {
+		return value.(int) >= 2
+	}); n != 2 {
+		t.Errorf("RemoveFunc should remove 2 entries, but removed %d", n)
+	}
+	if s := l.Stats(); s.Entries != 0 || s.Bytes != 0 {
+		t.Errorf("all entries should be removed: %+v", s)
+	}
+}
@@ -0,0 +1,417 @@
+package fetcher
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minodisk/resizer/logger"
+	"github.com/pkg/errors"
+)
+
+const (
+	extData = ".data"
+	extMeta = ".json"
+)
+
+// entry はキャッシュされた元画像の情報を表す。
+type entry struct {
+	URL          string    `json:"url"`
+	Origin       string    `json:"origin"`
+	ETag         string    `json:"etag"`
+	LastModified string    `json:"last_modified"`
+	ValidatedAt  time.Time `json:"validated_at"`
+	AccessedAt   time.Time `json:"accessed_at"`
+	Size         int64     `json:"size"`
+	// File は元画像のデータのファイル名。取得し直すたびに別のファイルに保存する。
+	File string `json:"file"`
+
+	key  string
+	refs int
+}
+
+// fetchCall は実行中の元画像の取得を表す。
+// 同じキーの元画像を同時に取得しようとした呼び出しは、取得を待って結果を共有する。
+type fetchCall struct {
+	wg       sync.WaitGroup
+	waiters  int
+	filename string
+	err      error
+}
+
+// Cache は取得した元画像を URL をキーにしてディスクにキャッシュする。
+// 複数のリクエストから同時に使用できる。
+//
+// ttl 以内に検証したキャッシュはそのまま使用し、それより古いキャッシュは
+// オリジンの ETag と Last-Modified を使った条件付きリクエストで再検証する。
+// キャッシュの合計サイズが maxBytes を超えるか、
+// maxAge の間参照されなかったキャッシュは破棄する。
+// 置き換えられたキャッシュのファイルは、参照が全て解放されるまで削除しない。
+type Cache struct {
+	dir      string
+	maxBytes int64
+	ttl      time.Duration
+	maxAge   time.Duration
+
+	mu      sync.Mutex
+	bytes   int64
+	entries map[string]*entry
+	// detached は置き換えられた後も参照されているファイルと、キャッシュしない一時ファイル。
+	detached map[string]*entry
+	calls    map[string]*fetchCall
+	hits     int64
+	misses   int64
+}
+
+// CacheStats はキャッシュの利用状況を表す。
+type CacheStats struct {
+	Hits    int64
+	Misses  int64
+	Entries int
+	Bytes   int64
+}
+
+// NewCache はディレクトリ dir に元画像をキャッシュする Cache を作成する。
+// ディレクトリに既にキャッシュされている元画像は引き続き使用する。
+func NewCache(dir string, maxBytes int64, ttl, maxAge time.Duration) (*Cache, error) {
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "resizer-sources")
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, errors.Wrap(err, "fail to create source cache directory")
+	}
+	c := &Cache{
+		dir:      filepath.Clean(dir),
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		maxAge:   maxAge,
+		entries:  make(map[string]*entry),
+		detached: make(map[string]*entry),
+		calls:    make(map[string]*fetchCall),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+// load はディレクトリにキャッシュされている元画像の情報を読み込む。
+// どのキャッシュからも参照されていないファイルは削除する。
+func (c *Cache) load() error {
+	tmps, err := filepath.Glob(filepath.Join(c.dir, "tmp-*"))
+	if err != nil {
+		return err
+	}
+	for _, tmp := range tmps {
+		os.Remove(tmp)
+	}
+	files, err := filepath.Glob(filepath.Join(c.dir, "*"+extMeta))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		key := strings.TrimSuffix(filepath.Base(file), extMeta)
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return errors.Wrap(err, "fail to read source cache")
+		}
+		e := &entry{key: key}
+		if err := json.Unmarshal(b, e); err != nil || !c.valid(e) {
+			os.Remove(file)
+			continue
+		}
+		c.entries[key] = e
+		c.bytes += e.Size
+	}
+	files, err = filepath.Glob(filepath.Join(c.dir, "*"+extData))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		name := filepath.Base(file)
+		if e, ok := c.entries[keyOf(name)]; !ok || e.File != name {
+			os.Remove(file)
+		}
+	}
+	return nil
+}
+
+// valid は読み込んだキャッシュ e のファイルが存在し、記録されたサイズと一致するかを判定する。
+func (c *Cache) valid(e *entry) bool {
+	if e.File == "" || filepath.Base(e.File) != e.File || keyOf(e.File) != e.key {
+		return false
+	}
+	info, err := os.Stat(c.dataPath(e))
+	return err == nil && info.Size() == e.Size
+}
+
+// Fetch は URL url の元画像をキャッシュから取得する。
+// キャッシュが存在しないか古ければ f を使ってオリジンから取得する。
+// 取得先から解決した URL の元画像は、url で指定された同じ URL の元画像とは別にキャッシュする。
+func (c *Cache) Fetch(f *Fetcher, url string) (string, error) {
+	req, err := f.newRequest(url)
+	if err != nil {
+		return "", err
+	}
+	var origin string
+	if u, ok := originFromContext(req.Context()); ok {
+		origin = u.String()
+	}
+	key := cacheKey(origin, url)
+	now := time.Now()
+
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if ok && now.Sub(e.ValidatedAt) < c.ttl {
+		e.refs++
+		e.AccessedAt = now
+		c.hits++
+		c.mu.Unlock()
+		return c.dataPath(e), nil
+	}
+	// 同じ元画像を取得中であれば、取得を待って結果を共有する
+	if call, ok := c.calls[key]; ok {
+		call.waiters++
+		c.mu.Unlock()
+		call.wg.Wait()
+		return call.filename, call.err
+	}
+	call := &fetchCall{
+		err: fmt.Errorf("can't fetch image %s: fetching was aborted", url),
+	}
+	call.wg.Add(1)
+	c.calls[key] = call
+	var etag, lastModified string
+	if ok {
+		etag, lastModified = e.ETag, e.LastModified
+	}
+	c.mu.Unlock()
+
+	// 取得に失敗しても、待っている呼び出しに結果を返す
+	defer func() {
+		c.mu.Lock()
+		if c.calls[key] == call {
+			delete(c.calls, key)
+		}
+		c.mu.Unlock()
+		call.wg.Done()
+	}()
+
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+	tmp, err := ioutil.TempFile(c.dir, "tmp-")
+	if err != nil {
+		call.err = errors.Wrap(err, "fail to create temporary file")
+		return "", call.err
+	}
+	tmp.Close()
+	resp, err := f.download(req, tmp.Name())
+	if err != nil {
+		os.Remove(tmp.Name())
+		call.err = err
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.calls, key)
+	// 取得を待っていた呼び出しの分も参照する
+	refs := 1 + call.waiters
+	call.filename, call.err = c.store(key, origin, url, resp, tmp.Name(), refs, now)
+	return call.filename, call.err
+}
+
+// store は取得した元画像のレスポンス resp とファイル tmp をキャッシュし、参照するファイルを返す。
+// 元画像は新しいファイルに保存し、置き換えたキャッシュのファイルは参照が全て解放されてから削除する。
+// c.mu をロックして呼び出さなければならない。
+func (c *Cache) store(key, origin, url string, resp *http.Response, tmp string, refs int, now time.Time) (string, error) {
+	// 再検証できた場合はキャッシュをそのまま使用する
+	if resp.StatusCode == http.StatusNotModified {
+		os.Remove(tmp)
+		e, ok := c.entries[key]
+		if !ok {
+			return "", fmt.Errorf("can't fetch image %s: cache was evicted while revalidating", url)
+		}
+		e.ValidatedAt = now
+		e.AccessedAt = now
+		e.refs += refs
+		c.hits += int64(refs)
+		c.save(e)
+		return c.dataPath(e), nil
+	}
+	c.misses++
+	c.hits += int64(refs - 1)
+
+	info, err := os.Stat(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	// キャッシュが禁止されている場合は一時ファイルとして扱い、参照が全て解放されたら削除する
+	if strings.Contains(resp.Header.Get("Cache-Control"), "no-store") || info.Size() > c.maxBytes {
+		c.detached[tmp] = &entry{refs: refs}
+		return tmp, nil
+	}
+	e := &entry{
+		URL:          url,
+		Origin:       origin,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		ValidatedAt:  now,
+		AccessedAt:   now,
+		Size:         info.Size(),
+		File:         fmt.Sprintf("%s-%x%s", key, now.UnixNano(), extData),
+		key:          key,
+		refs:         refs,
+	}
+	if err := os.Rename(tmp, c.dataPath(e)); err != nil {
+		os.Remove(tmp)
+		return "", errors.Wrap(err, "fail to store source cache")
+	}
+	if old, ok := c.entries[key]; ok {
+		c.detach(old)
+	}
+	c.entries[key] = e
+	c.bytes += e.Size
+	c.save(e)
+	c.evict()
+	return c.dataPath(e), nil
+}
+
+// Release は Fetch で返したファイル filename の参照を解放する。
+// filename がキャッシュされたファイルでなければ false を返す。
+func (c *Cache) Release(filename string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d, ok := c.detached[filename]; ok {
+		d.refs--
+		if d.refs <= 0 {
+			delete(c.detached, filename)
+			c.bytes -= d.Size
+			os.Remove(filename)
+		}
+		return true
+	}
+	if filepath.Dir(filename) != c.dir || !strings.HasSuffix(filename, extData) {
+		return false
+	}
+	name := filepath.Base(filename)
+	if e, ok := c.entries[keyOf(name)]; ok && e.File == name && e.refs > 0 {
+		e.refs--
+	}
+	c.evict()
+	return true
+}
+
+// Remove は URL url の元画像のキャッシュを、取得先から解決したものも含めて破棄する。
+// 参照中のファイルは参照が全て解放されてから削除する。
+func (c *Cache) Remove(url string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.entries {
+		if e.URL != url {
+			continue
+		}
+		c.detach(e)
+		os.Remove(c.metaPath(e.key))
+	}
+}
+
+// Stats はキャッシュの利用状況を返す。
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Hits:    c.hits,
+		Misses:  c.misses,
+		Entries: len(c.entries),
+		Bytes:   c.bytes,
+	}
+}
+
+// evict は古いキャッシュと、合計サイズが上限を超えた分のキャッシュを
+// 参照された時刻が古いものから破棄する。参照中のキャッシュは破棄しない。
+// c.mu をロックして呼び出さなければならない。
+func (c *Cache) evict() {
+	es := make([]*entry, 0, len(c.entries))
+	for _, e := range c.entries {
+		if e.refs == 0 {
+			es = append(es, e)
+		}
+	}
+	sort.Slice(es, func(i, j int) bool {
+		return es[i].AccessedAt.Before(es[j].AccessedAt)
+	})
+	now := time.Now()
+	for _, e := range es {
+		if c.bytes <= c.maxBytes && (c.maxAge <= 0 || now.Sub(e.AccessedAt) < c.maxAge) {
+			continue
+		}
+		c.bytes -= e.Size
+		delete(c.entries, e.key)
+		os.Remove(c.dataPath(e))
+		os.Remove(c.metaPath(e.key))
+	}
+}
+
+// detach はキャッシュ e を一覧から外す。
+// 参照中であればファイルを detached に移し、参照が全て解放されてから削除する。
+// c.mu をロックして呼び出さなければならない。
+func (c *Cache) detach(e *entry) {
+	delete(c.entries, e.key)
+	if e.refs > 0 {
+		c.detached[c.dataPath(e)] = e
+		return
+	}
+	c.bytes -= e.Size
+	os.Remove(c.dataPath(e))
+}
+
+// cacheKey は取得先 origin から解決した URL url の元画像のキャッシュのキーを返す。
+// 取得先から解決した URL は内部向けのアドレスにも接続するため、取得先を含めたキーにして
+// url で指定されたリクエストとキャッシュを共有しない。
+func cacheKey(origin, url string) string {
+	if origin == "" {
+		return fmt.Sprintf("%x", sha1.Sum([]byte(url)))
+	}
+	return fmt.Sprintf("%x", sha1.Sum([]byte(origin+"\n"+url)))
+}
+
+func (c *Cache) save(e *entry) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		logger.Default().Warn("fail to marshal source cache", "err", err)
+		return
+	}
+	if err := ioutil.WriteFile(c.metaPath(e.key), b, 0666); err != nil {
+		logger.Default().Warn("fail to write source cache", "err", err)
+	}
+}
+
+func (c *Cache) dataPath(e *entry) string {
+	return filepath.Join(c.dir, e.File)
+}
+
+func (c *Cache) metaPath(key string) string {
+	return filepath.Join(c.dir, key+extMeta)
+}
+
+// keyOf はキャッシュのファイル名 name からキーを返す。
+func keyOf(name string) string {
+	return strings.SplitN(name, "-", 2)[0]
+}
@@ -0,0 +1,161 @@
+package fetcher
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"github.com/minodisk/resizer/options"
+	"github.com/pkg/errors"
+)
+
+// config は URL u へのリクエストの設定と、設定を識別するキーを返す。
+// 取得先の設定を、ホストのパターンの設定より優先する。
+func (f *Fetcher) config(u *url.URL) (string, options.FetchConfig, bool) {
+	if o, ok := f.origins.Lookup(u); ok {
+		return "origin " + o.URL, o.FetchConfig, true
+	}
+	return f.configs.Lookup(u)
+}
+
+// setHeaders はリクエスト req にヘッダーと認証情報を設定する。
+func (f *Fetcher) setHeaders(req *http.Request) {
+	req.Header.Set("User-Agent", UserAgent)
+	_, c, ok := f.config(req.URL)
+	if !ok {
+		return
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+	for k, v := range c.Headers {
+		req.Header.Set(k, v)
+	}
+	if c.Username != "" || c.Password != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	if c.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.BearerToken)
+	}
+}
+
+// clearHeaders はリクエスト req から、URL u へのリクエストに設定したヘッダーと認証情報を取り除く。
+func (f *Fetcher) clearHeaders(req *http.Request, u *url.URL) {
+	_, c, ok := f.config(u)
+	if !ok {
+		return
+	}
+	for k := range c.Headers {
+		req.Header.Del(k)
+	}
+	req.Header.Del("Authorization")
+}
+
+// dumpRequest はリクエスト req を、認証情報とヘッダーの値を伏せて出力する。
+func (f *Fetcher) dumpRequest(req *http.Request) []byte {
+	r := *req
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	if r.Header.Get("Authorization") != "" {
+		r.Header.Set("Authorization", options.Redacted)
+	}
+	if _, c, ok := f.config(req.URL); ok {
+		for k := range c.Headers {
+			r.Header.Set(k, options.Redacted)
+		}
+	}
+	dump, _ := httputil.DumpRequest(&r, false)
+	return dump
+}
+
+// transport はリクエストの URL の設定に応じて、クライアント証明書を提示する Transport を使い分ける。
+// 取得先から解決したリクエストは、内部向けのアドレスへの接続を他のリクエストと共有しないように、
+// origin の Transport で送信する。
+type transport struct {
+	fetcher *Fetcher
+	base    *http.Transport
+	certs   map[string]*http.Transport
+	origin  *transport
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.origin != nil && toOrigin(req) {
+		return t.origin.RoundTrip(req)
+	}
+	if key, _, ok := t.fetcher.config(req.URL); ok {
+		if ct, ok := t.certs[key]; ok {
+			return ct.RoundTrip(req)
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// newTransport はオプション o から元画像を取得する Transport を作成する。
+// クライアント証明書が設定されている場合は、設定ごとに Transport を作成する。
+// 取得先が設定されている場合は、取得先へのリクエストに別の Transport を作成する。
+func (f *Fetcher) newTransport(o *options.Options) (http.RoundTripper, error) {
+	d := &dialer{
+		dialer: &net.Dialer{
+			Timeout:   o.FetchConnectTimeout,
+			KeepAlive: 30 * time.Second,
+		},
+		allowedNetworks: o.AllowedNetworks,
+	}
+
+	configs := map[string]options.FetchConfig{}
+	for _, origin := range o.Origins {
+		configs["origin "+origin.URL] = origin.FetchConfig
+	}
+	for p, c := range o.FetchConfigs {
+		configs[p] = c
+	}
+	certs := map[string]*tls.Config{}
+	for key, c := range configs {
+		if c.TLSCert == "" {
+			continue
+		}
+		cert, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "fail to load client certificate for '%s'", key)
+		}
+		certs[key] = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		}
+	}
+
+	newTransport := func(dial func(ctx context.Context, network, addr string) (net.Conn, error)) *transport {
+		newTransport := func(c *tls.Config) *http.Transport {
+			// プロキシを経由すると接続先のアドレスを検査できないため、プロキシは使用しない
+			return &http.Transport{
+				DialContext:           dial,
+				TLSClientConfig:       c,
+				TLSHandshakeTimeout:   o.FetchConnectTimeout,
+				ResponseHeaderTimeout: o.FetchTimeout,
+				IdleConnTimeout:       90 * time.Second,
+			}
+		}
+		t := &transport{
+			fetcher: f,
+			base:    newTransport(nil),
+			certs:   map[string]*http.Transport{},
+		}
+		for key, c := range certs {
+			t.certs[key] = newTransport(c)
+		}
+		return t
+	}
+	t := newTransport(d.DialContext)
+	if len(o.Origins) > 0 {
+		t.origin = newTransport(d.DialOrigin)
+	}
+	if len(t.certs) == 0 && t.origin == nil {
+		return t.base, nil
+	}
+	return t, nil
+}
@@ -0,0 +1,123 @@
+package fetcher
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/minodisk/resizer/options"
+)
+
+// forbiddenNetworks は元画像の取得で接続を禁止するネットワーク。
+// プライベート、ループバック、リンクローカル（クラウドのメタデータサーバーを含む）などの
+// 内部向けのアドレスが該当する。
+var forbiddenNetworks options.Networks
+
+func init() {
+	if err := forbiddenNetworks.Set(
+		"0.0.0.0/8," +
+			"10.0.0.0/8," +
+			"100.64.0.0/10," +
+			"127.0.0.0/8," +
+			"169.254.0.0/16," +
+			"172.16.0.0/12," +
+			"192.0.0.0/24," +
+			"192.168.0.0/16," +
+			"198.18.0.0/15," +
+			"224.0.0.0/4," +
+			"240.0.0.0/4," +
+			"::/128," +
+			"::1/128," +
+			"64:ff9b::/96," +
+			"fc00::/7," +
+			"fe80::/10," +
+			"ff00::/8",
+	); err != nil {
+		panic(err)
+	}
+}
+
+// dialer は名前解決の結果を検査してから接続する。
+// 禁止されたアドレスに解決される場合は接続せずに ForbiddenAddressError を返す。
+// 名前解決と接続の間にアドレスが変わらないように、解決したアドレスに直接接続する。
+type dialer struct {
+	dialer          *net.Dialer
+	allowedNetworks options.Networks
+}
+
+type originKey struct{}
+
+// withOrigin は取得先 o から解決した URL へのリクエストであることを示す Context を返す。
+func withOrigin(ctx context.Context, o options.Origin) context.Context {
+	return context.WithValue(ctx, originKey{}, o.Base())
+}
+
+// originFromContext は Context ctx のリクエストを解決した取得先の URL を返す。
+func originFromContext(ctx context.Context) (*url.URL, bool) {
+	u, ok := ctx.Value(originKey{}).(*url.URL)
+	return u, ok
+}
+
+// toOrigin はリクエスト req が、解決した取得先と同じスキーム、ホスト、ポートに送られるかを判定する。
+// リダイレクト先が取得先の外であれば false を返す。
+func toOrigin(req *http.Request) bool {
+	u, ok := originFromContext(req.Context())
+	return ok && req.URL.Scheme == u.Scheme && hostPort(req.URL) == hostPort(u)
+}
+
+// hostPort は URL u の接続先のホストとポートを返す。ポートの指定がなければスキームの既定のポートとする。
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(strings.ToLower(u.Hostname()), port)
+}
+
+func (d *dialer) allowed(ip net.IP) bool {
+	return d.allowedNetworks.Contains(ip) || !forbiddenNetworks.Contains(ip)
+}
+
+// DialOrigin は Context ctx のリクエストを解決した取得先のホストとポートであれば、
+// 内部向けのアドレスであっても接続する。それ以外のアドレスは DialContext と同様に検査する。
+func (d *dialer) DialOrigin(ctx context.Context, network, addr string) (net.Conn, error) {
+	u, ok := originFromContext(ctx)
+	return d.dial(ctx, network, addr, ok && strings.EqualFold(addr, hostPort(u)))
+}
+
+func (d *dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d.dial(ctx, network, addr, false)
+}
+
+// dial はアドレス addr に接続する。trusted が true の場合は解決したアドレスを検査しない。
+func (d *dialer) dial(ctx context.Context, network, addr string, trusted bool) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host}
+	}
+	for _, a := range addrs {
+		if !trusted && !d.allowed(a.IP) {
+			return nil, NewForbiddenAddressError(host, a.IP.String())
+		}
+	}
+	for _, a := range addrs {
+		var conn net.Conn
+		conn, err = d.dialer.DialContext(ctx, network, net.JoinHostPort(a.IP.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
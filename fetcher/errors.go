@@ -0,0 +1,139 @@
+package fetcher
+
+import "fmt"
+
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func NewStatusError(url string, code int) StatusError {
+	return StatusError{url, code}
+}
+
+func (err StatusError) Error() string {
+	return fmt.Sprintf("can't fetch image %s: status code %d", err.URL, err.StatusCode)
+}
+
+type TimeoutError struct {
+	URL string
+}
+
+func NewTimeoutError(url string) TimeoutError {
+	return TimeoutError{url}
+}
+
+func (err TimeoutError) Error() string {
+	return fmt.Sprintf("fetching image %s timed out", err.URL)
+}
+
+type TooLargeError struct {
+	URL string
+	Max int64
+}
+
+func NewTooLargeError(url string, max int64) TooLargeError {
+	return TooLargeError{url, max}
+}
+
+func (err TooLargeError) Error() string {
+	return fmt.Sprintf("image %s is larger than %d bytes", err.URL, err.Max)
+}
+
+type TooManyRedirectsError struct {
+	URL string
+	Max int
+}
+
+func NewTooManyRedirectsError(url string, max int) TooManyRedirectsError {
+	return TooManyRedirectsError{url, max}
+}
+
+func (err TooManyRedirectsError) Error() string {
+	return fmt.Sprintf("fetching image %s is redirected more than %d times", err.URL, err.Max)
+}
+
+type InvalidRedirectHostError struct {
+	Host string
+}
+
+func NewInvalidRedirectHostError(host string) InvalidRedirectHostError {
+	return InvalidRedirectHostError{host}
+}
+
+func (err InvalidRedirectHostError) Error() string {
+	return fmt.Sprintf("redirect to host '%s' isn't allowed", err.Host)
+}
+
+type InvalidContentTypeError struct {
+	ContentType string
+}
+
+func NewInvalidContentTypeError(contentType string) InvalidContentTypeError {
+	return InvalidContentTypeError{contentType}
+}
+
+func (err InvalidContentTypeError) Error() string {
+	return fmt.Sprintf("content type '%s' isn't allowed", err.ContentType)
+}
+
+type ForbiddenAddressError struct {
+	Host string
+	IP   string
+}
+
+func NewForbiddenAddressError(host, ip string) ForbiddenAddressError {
+	return ForbiddenAddressError{host, ip}
+}
+
+func (err ForbiddenAddressError) Error() string {
+	return fmt.Sprintf("host '%s' resolves to forbidden address %s", err.Host, err.IP)
+}
+
+type UnsupportedSchemeError struct {
+	Scheme string
+}
+
+func NewUnsupportedSchemeError(scheme string) UnsupportedSchemeError {
+	return UnsupportedSchemeError{scheme}
+}
+
+func (err UnsupportedSchemeError) Error() string {
+	return fmt.Sprintf("scheme '%s' isn't supported", err.Scheme)
+}
+
+type InvalidBucketError struct {
+	Bucket string
+}
+
+func NewInvalidBucketError(bucket string) InvalidBucketError {
+	return InvalidBucketError{bucket}
+}
+
+func (err InvalidBucketError) Error() string {
+	return fmt.Sprintf("bucket name '%s' is invalid", err.Bucket)
+}
+
+type FileNotFoundError struct {
+	URL string
+}
+
+func NewFileNotFoundError(url string) FileNotFoundError {
+	return FileNotFoundError{url}
+}
+
+func (err FileNotFoundError) Error() string {
+	return fmt.Sprintf("source file %s isn't found", err.URL)
+}
+
+type ForbiddenFileError struct {
+	URL string
+}
+
+func NewForbiddenFileError(url string) ForbiddenFileError {
+	return ForbiddenFileError{url}
+}
+
+func (err ForbiddenFileError) Error() string {
+	return fmt.Sprintf("source file %s is outside of the source root", err.URL)
+}
@@ -1,27 +1,33 @@
 package fetcher
 
 import (
+	"bufio"
+	"context"
 	"crypto/md5"
 	"fmt"
 	"io"
-	"log"
+	"net"
 	"net/http"
-	"net/http/httputil"
+	"net/url"
 	"os"
 	"path"
+	"strings"
 	"time"
 
+	"github.com/minodisk/resizer/logger"
+	"github.com/minodisk/resizer/options"
 	"github.com/pkg/errors"
 )
 
 const (
 	UserAgent = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_10_3) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/42.0.2311.135 Safari/537.36"
+
+	sniffLen = 512
 )
 
 var (
-	tempDir = path.Join(os.TempDir(), "resizer")
-	expires time.Duration
-	client  *http.Client
+	tempDir        = path.Join(os.TempDir(), "resizer")
+	defaultFetcher *Fetcher
 )
 
 func init() {
@@ -31,71 +37,277 @@ func init() {
 }
 
 func _init() error {
-	var err error
-	expires, err = time.ParseDuration("1h")
-	if err != nil {
-		return err
-	}
 	if err := os.RemoveAll(tempDir); err != nil {
 		return err
 	}
 	if err := os.MkdirAll(tempDir, 0777); err != nil {
 		return err
 	}
+	var err error
+	defaultFetcher, err = New(&options.Options{})
+	return err
+}
 
-	client = new(http.Client)
+// Fetcher は元画像を取得する。
+type Fetcher struct {
+	client       *http.Client
+	cache        *Cache
+	allowedHosts options.Hosts
+	origins      options.Origins
+	configs      options.FetchConfigs
+	sources      map[string]Source
+	s3           *s3Source
+	maxRedirects int
+	maxSize      int64
+	// ctx はリクエストに付加する Context。ログの出力先を持つ。
+	ctx context.Context
+	// origin は元画像の URL を解決した取得先の名前。
+	origin string
+}
 
-	return nil
+// New はオプション o から Fetcher を作成する。
+// 元画像のキャッシュのサイズが指定されている場合は、取得した元画像をディスクにキャッシュする。
+// タイムアウトや元画像のサイズの上限が 0 の場合、それらは制限されない。
+func New(o *options.Options) (*Fetcher, error) {
+	f := &Fetcher{
+		allowedHosts: o.AllowedHosts,
+		origins:      o.Origins,
+		configs:      o.FetchConfigs,
+		sources:      newSources(o),
+		s3:           newS3Source(o),
+		maxRedirects: o.MaxRedirects,
+		maxSize:      o.MaxFetchSize,
+		ctx:          context.Background(),
+	}
+	if f.maxRedirects <= 0 {
+		f.maxRedirects = options.DefaultMaxRedirects
+	}
+	t, err := f.newTransport(o)
+	if err != nil {
+		return nil, err
+	}
+	f.client = &http.Client{
+		Transport:     t,
+		Timeout:       o.FetchTimeout,
+		CheckRedirect: f.checkRedirect,
+	}
+	if o.SourceCacheSize > 0 {
+		c, err := NewCache(o.SourceCacheDir, o.SourceCacheSize, o.SourceCacheTTL, o.SourceCacheMaxAge)
+		if err != nil {
+			return nil, err
+		}
+		f.cache = c
+	}
+	return f, nil
 }
 
-func Fetch(url string) (string, error) {
-	sum := md5.Sum([]byte(fmt.Sprintf("%s-%d", url, time.Now().UnixNano())))
-	f := fmt.Sprintf("%x", sum)
-	filename := path.Join(tempDir, f)
+// WithContext は Context ctx で元画像を取得する Fetcher を返す。
+// ログは ctx の Logger に出力する。
+func (f *Fetcher) WithContext(ctx context.Context) *Fetcher {
+	c := *f
+	c.ctx = ctx
+	return &c
+}
+
+// WithOrigin は名前 name の取得先から解決した URL の元画像を取得する Fetcher を返す。
+// 取得先のスキーム、ホスト、ポートへのリクエストに限り、内部向けのアドレスであっても接続する。
+func (f *Fetcher) WithOrigin(name string) *Fetcher {
+	c := *f
+	c.origin = name
+	return &c
+}
 
-	log.Printf("file is temporary saved as %s\n", filename)
+// RemoveCache は URL url の元画像のキャッシュを破棄する。
+func (f *Fetcher) RemoveCache(url string) {
+	if f.cache == nil {
+		return
+	}
+	f.cache.Remove(url)
+}
 
-	req, err := http.NewRequest("GET", url, nil)
+// CacheStats は元画像のキャッシュの利用状況を返す。
+// キャッシュが無効な場合はゼロ値を返す。
+func (f *Fetcher) CacheStats() CacheStats {
+	if f.cache == nil {
+		return CacheStats{}
+	}
+	return f.cache.Stats()
+}
+
+// Fetch は URL rawurl の元画像をキャッシュせずに取得する。
+func Fetch(rawurl string) (string, error) {
+	return defaultFetcher.Fetch(rawurl)
+}
+
+// Clean は Fetch で取得した元画像のファイルを削除する。
+func Clean(filename string) error {
+	return defaultFetcher.Clean(filename)
+}
+
+// Fetch は URL rawurl の元画像を取得してファイルに保存し、そのパスを返す。
+// s3 スキームの元画像は HTTP と同じクライアントで、その他のスキームの元画像はスキームに対応する Source から取得する。
+// 使い終わったファイルは Clean に渡さなければならない。
+func (f *Fetcher) Fetch(rawurl string) (string, error) {
+	u, err := url.Parse(rawurl)
 	if err != nil {
-		return "", errors.Wrap(err, "fail to new request")
+		return "", errors.Wrap(err, "fail to parse URL")
+	}
+	switch u.Scheme {
+	case "http", "https":
+	case "s3":
+		return f.fetchS3(u)
+	default:
+		s, ok := f.sources[u.Scheme]
+		if !ok {
+			return "", NewUnsupportedSchemeError(u.Scheme)
+		}
+		return f.fetchSource(s, u)
 	}
-	req.Header.Set("User-Agent", UserAgent)
-	resp, err := client.Do(req)
+
+	if f.cache != nil {
+		return f.cache.Fetch(f, rawurl)
+	}
+	sum := md5.Sum([]byte(fmt.Sprintf("%s-%d", rawurl, time.Now().UnixNano())))
+	filename := path.Join(tempDir, fmt.Sprintf("%x", sum))
+	logger.FromContext(f.ctx).Debug("save source image temporarily", "url", rawurl, "filename", filename)
+
+	req, err := f.newRequest(rawurl)
 	if err != nil {
-		return "", errors.Wrap(err, "fail to GET")
+		return "", err
+	}
+	if _, err := f.download(req, filename); err != nil {
+		// 途中まで保存したファイルを残さない
+		os.Remove(filename)
+		return "", err
+	}
+	return filename, nil
+}
+
+// Clean は Fetch で取得した元画像のファイルを片付ける。
+// キャッシュされているファイルは削除されずに再利用される。
+func (f *Fetcher) Clean(filename string) error {
+	if f.cache != nil && f.cache.Release(filename) {
+		return nil
 	}
+	return os.Remove(filename)
+}
 
-	dump, _ := httputil.DumpRequest(req, true)
+// checkRedirect はリダイレクトの回数とリダイレクト先のホストを検査する。
+func (f *Fetcher) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > f.maxRedirects {
+		return NewTooManyRedirectsError(via[0].URL.String(), f.maxRedirects)
+	}
+	if _, ok := f.origins.Lookup(req.URL); !ok && !f.allowedHosts.Match(req.URL) {
+		return NewInvalidRedirectHostError(req.URL.Host)
+	}
+	// 元のリクエストのヘッダーや認証情報をリダイレクト先に送らないように、一旦取り除いてから設定し直す
+	f.clearHeaders(req, via[0].URL)
+	f.setHeaders(req)
+	return nil
+}
 
-	log.Printf("Dump: %s\n", dump)
+// newRequest は URL rawurl の元画像を GET するリクエストを作成する。
+func (f *Fetcher) newRequest(rawurl string) (*http.Request, error) {
+	req, err := http.NewRequest("GET", rawurl, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to new request")
+	}
+	ctx := f.ctx
+	if o, ok := f.origins[f.origin]; ok && o.Match(req.URL) {
+		ctx = withOrigin(ctx, o)
+	}
+	return req.WithContext(ctx), nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("not ok: StatusCode=%d\n", resp.StatusCode)
-		return "", fmt.Errorf("can't fetch image %s", url)
+// download はリクエスト req を送信し、レスポンスのステータスコードが 200 であれば
+// ボディをファイル filename に保存する。
+// ステータスコードが 304 の場合は保存せずにレスポンスを返す。
+func (f *Fetcher) download(req *http.Request, filename string) (*http.Response, error) {
+	f.setHeaders(req)
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, f.convertError(req, err)
+	}
+	l := logger.FromContext(req.Context())
+	if l.Enabled(logger.LevelDebug) {
+		l.Debug("fetch source image", "request", string(f.dumpRequest(req)))
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			log.Println(errors.Wrap(err, "fail to close response body"))
+			l.Warn("fail to close response body", "err", err)
 		}
 	}()
-	log.Printf("ok: StatusCode=%d\n", resp.StatusCode)
+	l.Debug("fetched source image", "url", req.URL, "status", resp.StatusCode)
+	if resp.StatusCode == http.StatusNotModified {
+		return resp, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, NewStatusError(req.URL.String(), resp.StatusCode)
+	}
+	if f.maxSize > 0 && resp.ContentLength > f.maxSize {
+		return nil, NewTooLargeError(req.URL.String(), f.maxSize)
+	}
+
+	if err := f.store(resp.Body, req.URL.String(), filename); err != nil {
+		return nil, f.convertError(req, err)
+	}
+	return resp, nil
+}
+
+// store は URL rawurl の元画像のデータ r が画像であることを確認して、ファイル filename に保存する。
+func (f *Fetcher) store(r io.Reader, rawurl, filename string) error {
+	// デコードする前に内容から画像であることを確認する
+	body := bufio.NewReaderSize(r, sniffLen)
+	head, err := body.Peek(sniffLen)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return err
+	}
+	if ct := http.DetectContentType(head); !strings.HasPrefix(ct, "image/") {
+		return NewInvalidContentTypeError(ct)
+	}
 
 	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
 	defer func() {
 		if err := file.Close(); err != nil {
-			log.Println(errors.Wrap(err, "fail to close file"))
+			logger.FromContext(f.ctx).Warn("fail to close file", "filename", filename, "err", err)
 		}
 	}()
+	r = body
+	if f.maxSize > 0 {
+		r = io.LimitReader(body, f.maxSize+1)
+	}
+	n, err := io.Copy(file, r)
 	if err != nil {
-		return "", err
+		return err
 	}
-	if _, err := io.Copy(file, resp.Body); err != nil {
-		return "", err
+	if f.maxSize > 0 && n > f.maxSize {
+		return NewTooLargeError(rawurl, f.maxSize)
 	}
-
-	return filename, nil
+	return nil
 }
 
-func Clean(filename string) error {
-	return os.Remove(filename)
+// convertError は元画像の取得中に発生したエラーを型付きのエラーに変換する。
+func (f *Fetcher) convertError(req *http.Request, err error) error {
+	switch e := err.(type) {
+	case InvalidContentTypeError, TooLargeError:
+		return e
+	}
+	if ue, ok := err.(*url.Error); ok {
+		switch e := errors.Cause(ue.Err).(type) {
+		case TooManyRedirectsError, InvalidRedirectHostError, ForbiddenAddressError:
+			return e
+		}
+		if oe, ok := ue.Err.(*net.OpError); ok {
+			if e, ok := oe.Err.(ForbiddenAddressError); ok {
+				return e
+			}
+		}
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return NewTimeoutError(req.URL.String())
+	}
+	return errors.Wrap(err, "fail to GET")
 }
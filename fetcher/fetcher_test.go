@@ -5,25 +5,37 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/minodisk/resizer/fetcher"
+	"github.com/minodisk/resizer/options"
 	"github.com/minodisk/resizer/testutil"
 )
 
 var (
 	mockServer *httptest.Server
+	// loopback はテスト用のサーバーに接続するために許可するネットワーク。
+	loopback options.Networks
 )
 
 func TestMain(m *testing.M) {
+	if err := loopback.Set("127.0.0.0/8,::1"); err != nil {
+		panic(err)
+	}
 	if err := testutil.DownloadFixtures("f-png24.png"); err != nil {
 		panic(err)
 	}
 
 	mockServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, testutil.DirFixtures)
+		http.ServeFile(w, r, filepath.Join(testutil.DirFixtures, r.URL.Path[1:]))
 	}))
 
 	code := m.Run()
@@ -48,7 +60,13 @@ func TestFetchAndClean(t *testing.T) {
 
 	// fetcher.Fetchを実行し、戻り値のパスにファイルが存在していることをテストする
 	// 同一のデータが保存されていることをテストする
-	filename, err := fetcher.Fetch(url)
+	f, err := fetcher.New(&options.Options{
+		AllowedNetworks: loopback,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename, err := f.Fetch(url)
 	if err != nil {
 		t.Fatalf("fail to Fetch: error=%v", err)
 	}
@@ -61,10 +79,738 @@ func TestFetchAndClean(t *testing.T) {
 	}
 
 	// fetcher.Cleanを実行し、パスにファイルが存在していないことをテストする
-	if err := fetcher.Clean(filename); err != nil {
+	if err := f.Clean(filename); err != nil {
 		t.Fatalf("fail to clear: error=%v", err)
 	}
 	if _, err := os.Stat(filename); err == nil {
 		t.Errorf("%s was not cleaned", filename)
 	}
 }
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resizer-fetcher-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	png, err := ioutil.ReadFile(filepath.Join(testutil.DirFixtures, "f-png24.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var requests, notModified int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(png)
+	}))
+	defer server.Close()
+
+	f, err := fetcher.New(&options.Options{
+		AllowedNetworks: loopback,
+		SourceCacheDir:  dir,
+		SourceCacheSize: 1 << 20,
+		SourceCacheTTL:  time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetch := func() {
+		filename, err := f.Fetch(server.URL)
+		if err != nil {
+			t.Fatalf("fail to Fetch: error=%v", err)
+		}
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("fail to read file %s: error=%v", filename, err)
+		}
+		if !reflect.DeepEqual(b, png) {
+			t.Errorf("different content between server file and cached file")
+		}
+		if err := f.Clean(filename); err != nil {
+			t.Fatalf("fail to clean: error=%v", err)
+		}
+		if _, err := os.Stat(filename); err != nil {
+			t.Errorf("cached file %s shouldn't be cleaned", filename)
+		}
+	}
+
+	fetch()
+	fetch()
+	if requests != 1 {
+		t.Errorf("fresh cache should be used without requests: %d requests", requests)
+	}
+
+	// 再検証が必要になるように TTL を 0 にしたキャッシュで同じディレクトリを開き直す
+	f, err = fetcher.New(&options.Options{
+		AllowedNetworks: loopback,
+		SourceCacheDir:  dir,
+		SourceCacheSize: 1 << 20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetch()
+	if requests != 2 || notModified != 1 {
+		t.Errorf("stale cache should be revalidated: %d requests, %d not modified", requests, notModified)
+	}
+}
+
+func TestCacheReplace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resizer-fetcher-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	png, err := ioutil.ReadFile(filepath.Join(testutil.DirFixtures, "f-png24.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 取得するたびに内容と ETag が変わる
+	var version byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version++
+		w.Header().Set("ETag", fmt.Sprintf(`"v%d"`, version))
+		w.Write(append(png, version))
+	}))
+	defer server.Close()
+
+	f, err := fetcher.New(&options.Options{
+		AllowedNetworks: loopback,
+		SourceCacheDir:  dir,
+		SourceCacheSize: 1 << 20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := f.Fetch(server.URL)
+	if err != nil {
+		t.Fatalf("fail to Fetch: error=%v", err)
+	}
+	// 参照中のキャッシュを置き換えても、参照しているファイルの内容は変わらない
+	filename, err := f.Fetch(server.URL)
+	if err != nil {
+		t.Fatalf("fail to Fetch: error=%v", err)
+	}
+	if filename == old {
+		t.Fatalf("replaced cache should be stored in another file")
+	}
+	for name, v := range map[string]byte{old: 1, filename: 2} {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatalf("fail to read file %s: error=%v", name, err)
+		}
+		if !reflect.DeepEqual(b, append(png, v)) {
+			t.Errorf("file %s should have content of version %d", name, v)
+		}
+	}
+	if err := f.Clean(old); err != nil {
+		t.Fatalf("fail to clean: error=%v", err)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("replaced file %s should be removed after released: error=%v", old, err)
+	}
+	if err := f.Clean(filename); err != nil {
+		t.Fatalf("fail to clean: error=%v", err)
+	}
+	if _, err := os.Stat(filename); err != nil {
+		t.Errorf("cached file %s shouldn't be cleaned", filename)
+	}
+}
+
+func TestCacheCoalesce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resizer-fetcher-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		// 同時に取得しようとした呼び出しが揃うのを待つ
+		time.Sleep(100 * time.Millisecond)
+		if r.URL.Path == "/no-store.png" {
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		http.ServeFile(w, r, filepath.Join(testutil.DirFixtures, "f-png24.png"))
+	}))
+	defer server.Close()
+
+	f, err := fetcher.New(&options.Options{
+		AllowedNetworks: loopback,
+		SourceCacheDir:  dir,
+		SourceCacheSize: 1 << 20,
+		SourceCacheTTL:  time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/a.png", "/no-store.png"} {
+		atomic.StoreInt32(&requests, 0)
+		var wg sync.WaitGroup
+		filenames := make([]string, 5)
+		for i := range filenames {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				filename, err := f.Fetch(server.URL + path)
+				if err != nil {
+					t.Errorf("%s: fail to Fetch: error=%v", path, err)
+					return
+				}
+				filenames[i] = filename
+			}(i)
+		}
+		wg.Wait()
+		if n := atomic.LoadInt32(&requests); n != 1 {
+			t.Errorf("%s: concurrent misses should be fetched once, but fetched %d times", path, n)
+		}
+		for _, filename := range filenames {
+			if filename != filenames[0] {
+				t.Errorf("%s: concurrent misses should share the file: %s, %s", path, filenames[0], filename)
+			}
+			if err := f.Clean(filename); err != nil {
+				t.Fatalf("fail to clean: error=%v", err)
+			}
+		}
+		// キャッシュしない元画像のファイルは、全ての参照が解放されたら削除する
+		if _, err := os.Stat(filenames[0]); path == "/no-store.png" && !os.IsNotExist(err) {
+			t.Errorf("%s: file %s should be removed after released: error=%v", path, filenames[0], err)
+		}
+	}
+}
+
+func TestFetchErrors(t *testing.T) {
+	png, err := ioutil.ReadFile(filepath.Join(testutil.DirFixtures, "f-png24.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(png)
+	})
+	mux.HandleFunc("/html", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<!DOCTYPE html><html></html>"))
+	})
+	mux.HandleFunc("/notfound", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write(png)
+	})
+	// Content-Length を付けずにボディの途中まで書き込む
+	mux.HandleFunc("/chunked", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(png[:len(png)/2])
+		w.(http.Flusher).Flush()
+		w.Write(png[len(png)/2:])
+	})
+	mux.HandleFunc("/stall", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(png[:len(png)/2])
+		w.(http.Flusher).Flush()
+		time.Sleep(200 * time.Millisecond)
+		w.Write(png[len(png)/2:])
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/redirect", http.StatusFound)
+	})
+	mux.HandleFunc("/external", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://example.com/image", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name    string
+		options options.Options
+		path    string
+		err     error
+	}{
+		{
+			"ok",
+			options.Options{},
+			"/image",
+			nil,
+		},
+		{
+			"not ok status",
+			options.Options{},
+			"/notfound",
+			fetcher.NewStatusError(server.URL+"/notfound", http.StatusNotFound),
+		},
+		{
+			"too large",
+			options.Options{
+				MaxFetchSize: int64(len(png) - 1),
+			},
+			"/image",
+			fetcher.NewTooLargeError(server.URL+"/image", int64(len(png)-1)),
+		},
+		{
+			"too large without content length",
+			options.Options{
+				MaxFetchSize: int64(len(png) - 1),
+			},
+			"/chunked",
+			fetcher.NewTooLargeError(server.URL+"/chunked", int64(len(png)-1)),
+		},
+		{
+			"not image",
+			options.Options{},
+			"/html",
+			fetcher.NewInvalidContentTypeError("text/html; charset=utf-8"),
+		},
+		{
+			"timeout",
+			options.Options{
+				FetchTimeout: 50 * time.Millisecond,
+			},
+			"/slow",
+			fetcher.NewTimeoutError(server.URL + "/slow"),
+		},
+		{
+			"timeout while reading body",
+			options.Options{
+				FetchTimeout: 50 * time.Millisecond,
+			},
+			"/stall",
+			fetcher.NewTimeoutError(server.URL + "/stall"),
+		},
+		{
+			"too many redirects",
+			options.Options{
+				MaxRedirects: 3,
+			},
+			"/redirect",
+			fetcher.NewTooManyRedirectsError(server.URL+"/redirect", 3),
+		},
+		{
+			"redirect to disallowed host",
+			options.Options{
+				AllowedHosts: options.MustHosts(u.Host),
+			},
+			"/external",
+			fetcher.NewInvalidRedirectHostError("example.com"),
+		},
+	} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			c.options.AllowedNetworks = loopback
+			f, err := fetcher.New(&c.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := tempFiles(t)
+			filename, err := f.Fetch(server.URL + c.path)
+			if err == nil {
+				defer f.Clean(filename)
+			} else if after := tempFiles(t); len(after) != len(before) {
+				t.Errorf("partially fetched file should be removed, but got %v", after)
+			}
+			if !reflect.DeepEqual(err, c.err) {
+				t.Errorf("error\n got: %+v\nwant: %+v", err, c.err)
+			}
+		})
+	}
+}
+
+// tempFiles は元画像を一時的に保存するディレクトリのファイルを返す。
+func tempFiles(t *testing.T) []string {
+	files, err := filepath.Glob(filepath.Join(os.TempDir(), "resizer", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestForbiddenAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("request to forbidden address shouldn't be sent")
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name string
+		url  string
+		err  error
+	}{
+		{
+			"loopback",
+			server.URL,
+			fetcher.NewForbiddenAddressError(u.Hostname(), u.Hostname()),
+		},
+		{
+			"localhost",
+			fmt.Sprintf("http://localhost:%s", u.Port()),
+			fetcher.NewForbiddenAddressError("localhost", "127.0.0.1"),
+		},
+		{
+			"metadata",
+			"http://169.254.169.254/computeMetadata/v1/",
+			fetcher.NewForbiddenAddressError("169.254.169.254", "169.254.169.254"),
+		},
+		{
+			"private",
+			"http://10.0.0.1/",
+			fetcher.NewForbiddenAddressError("10.0.0.1", "10.0.0.1"),
+		},
+	} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			f, err := fetcher.New(&options.Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = f.Fetch(c.url)
+			if e, ok := err.(fetcher.ForbiddenAddressError); ok && c.name == "localhost" {
+				// localhost は ::1 に解決される場合もある
+				err = fetcher.NewForbiddenAddressError(e.Host, "127.0.0.1")
+			}
+			if !reflect.DeepEqual(err, c.err) {
+				t.Errorf("error\n got: %+v\nwant: %+v", err, c.err)
+			}
+		})
+	}
+}
+
+func TestOrigin(t *testing.T) {
+	png, err := ioutil.ReadFile(filepath.Join(testutil.DirFixtures, "f-png24.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 取得先の外にはヘッダーと認証情報を送らない
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "" || r.Header.Get("Authorization") != "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write(png)
+	}))
+	defer other.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if r.Header.Get("X-Token") != "secret" || !ok || user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/images/redirect":
+			http.Redirect(w, r, other.URL, http.StatusFound)
+		default:
+			w.Write(png)
+		}
+	}))
+	defer server.Close()
+
+	var origin options.Origin
+	if err := origin.UnmarshalJSON([]byte(fmt.Sprintf(`{
+		"url": "%s/images/",
+		"headers": {"X-Token": "secret"},
+		"username": "user",
+		"password": "pass"
+	}`, server.URL))); err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forbidden := fetcher.NewForbiddenAddressError(u.Hostname(), u.Hostname())
+
+	// 取得先から解決した URL はループバックアドレスであっても許可されたネットワークの指定なしに接続できる
+	f, err := fetcher.New(&options.Options{
+		AllowedHosts: options.MustHosts(other.Listener.Addr().String()),
+		Origins:      options.Origins{"images": origin},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename, err := f.WithOrigin("images").Fetch(server.URL + "/images/a.png")
+	if err != nil {
+		t.Fatalf("fail to Fetch from origin: error=%v", err)
+	}
+	f.Clean(filename)
+	for _, c := range []struct {
+		name string
+		f    *fetcher.Fetcher
+		url  string
+	}{
+		// 取得した接続を取得先から解決していないリクエストで再利用しない
+		{"not resolved from origin", f, server.URL + "/images/a.png"},
+		{"unknown origin", f.WithOrigin("unknown"), server.URL + "/images/a.png"},
+		{"outside origin", f.WithOrigin("images"), server.URL + "/a.png"},
+		{"another port", f.WithOrigin("images"), other.URL + "/images/a.png"},
+		{"redirect outside origin", f.WithOrigin("images"), server.URL + "/images/redirect"},
+	} {
+		if _, err := c.f.Fetch(c.url); !reflect.DeepEqual(err, forbidden) {
+			t.Errorf("%s: error\n got: %+v\nwant: %+v", c.name, err, forbidden)
+		}
+	}
+
+	// 取得先から解決した URL でキャッシュした元画像を、解決していないリクエストに返さない
+	dir, err := ioutil.TempDir("", "resizer-fetcher-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cached, err := fetcher.New(&options.Options{
+		Origins:         options.Origins{"images": origin},
+		SourceCacheDir:  dir,
+		SourceCacheSize: 1 << 20,
+		SourceCacheTTL:  time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename, err = cached.WithOrigin("images").Fetch(server.URL + "/images/a.png")
+	if err != nil {
+		t.Fatalf("fail to Fetch from origin with cache: error=%v", err)
+	}
+	cached.Clean(filename)
+	if _, err := cached.Fetch(server.URL + "/images/a.png"); !reflect.DeepEqual(err, forbidden) {
+		t.Errorf("cache from origin shouldn't be shared: error\n got: %+v\nwant: %+v", err, forbidden)
+	}
+
+	// 許可されたネットワークへのリダイレクト先には取得先のヘッダーと認証情報を送らない
+	f, err = fetcher.New(&options.Options{
+		AllowedHosts:    options.MustHosts(other.Listener.Addr().String()),
+		AllowedNetworks: loopback,
+		Origins:         options.Origins{"images": origin},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename, err = f.WithOrigin("images").Fetch(server.URL + "/images/redirect")
+	if err != nil {
+		t.Fatalf("fail to Fetch redirected from origin: error=%v", err)
+	}
+	f.Clean(filename)
+	if _, err := f.Fetch(server.URL + "/a.png"); err == nil {
+		t.Errorf("URL outside the origin should be fetched without credentials")
+	}
+}
+
+func TestFetchConfig(t *testing.T) {
+	png, err := ioutil.ReadFile(filepath.Join(testutil.DirFixtures, "f-png24.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" ||
+			r.Header.Get("X-Api-Key") != "key" ||
+			r.Header.Get("User-Agent") != "resizer-test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write(png)
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs, err := options.NewFetchConfigs(map[string]options.FetchConfig{
+		u.Hostname() + ":*/images/": options.FetchConfig{
+			Headers:     map[string]string{"X-Api-Key": "key"},
+			BearerToken: "token",
+			UserAgent:   "resizer-test",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := fetcher.New(&options.Options{
+		AllowedNetworks: loopback,
+		FetchConfigs:    configs,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename, err := f.Fetch(server.URL + "/images/a.png")
+	if err != nil {
+		t.Fatalf("fail to Fetch with config: error=%v", err)
+	}
+	f.Clean(filename)
+	if _, err := f.Fetch(server.URL + "/a.png"); !reflect.DeepEqual(err, fetcher.NewStatusError(server.URL+"/a.png", http.StatusUnauthorized)) {
+		t.Errorf("URL not matched with the pattern should be fetched without config, but got %v", err)
+	}
+}
+
+func TestFileSource(t *testing.T) {
+	root, err := ioutil.TempDir("", "resizer-source-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	png, err := ioutil.ReadFile(filepath.Join(testutil.DirFixtures, "f-png24.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "images"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "images", "a.png"), png, 0666); err != nil {
+		t.Fatal(err)
+	}
+	outside, err := filepath.Abs(filepath.Join(testutil.DirFixtures, "f-png24.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "images", "link.png")); err != nil {
+		t.Fatal(err)
+	}
+
+	without, err := fetcher.New(&options.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := without.Fetch("file:///images/a.png"); !reflect.DeepEqual(err, fetcher.NewUnsupportedSchemeError("file")) {
+		t.Errorf("file scheme shouldn't be supported without source root, but got %v", err)
+	}
+
+	f, err := fetcher.New(&options.Options{
+		SourceRoot: root,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		url string
+		err error
+	}{
+		{"file:///images/a.png", nil},
+		{"file://localhost/images/a.png", nil},
+		{"file:///../images/a.png", nil},
+		{"file://example.com/images/a.png", fetcher.NewFileNotFoundError("file://example.com/images/a.png")},
+		{"file:///images/b.png", fetcher.NewFileNotFoundError("file:///images/b.png")},
+		{"file:///images", fetcher.NewFileNotFoundError("file:///images")},
+		{"file:///images/link.png", fetcher.NewForbiddenFileError("file:///images/link.png")},
+	} {
+		filename, err := f.Fetch(c.url)
+		if c.err == nil {
+			if err != nil {
+				t.Errorf("%s: fail to Fetch: error=%v", c.url, err)
+				continue
+			}
+			b, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(b, png) {
+				t.Errorf("%s: different content between source file and fetched file", c.url)
+			}
+			if err := f.Clean(filename); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(err, c.err) {
+			t.Errorf("%s:\n got: %v\nwant: %v", c.url, err, c.err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "images", "a.png")); err != nil {
+		t.Errorf("source file shouldn't be cleaned: %v", err)
+	}
+
+	slash, err := fetcher.New(&options.Options{
+		SourceRoot: "/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	abs, err := filepath.EvalSymlinks(filepath.Join(root, "images", "a.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename, err := slash.Fetch("file://" + filepath.ToSlash(abs))
+	if err != nil {
+		t.Fatalf("file under root / should be fetched, but got %v", err)
+	}
+	if err := slash.Clean(filename); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestS3Source(t *testing.T) {
+	png, err := ioutil.ReadFile(filepath.Join(testutil.DirFixtures, "f-png24.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential := regexp.MustCompile(`^AWS4-HMAC-SHA256 Credential=AKID/\d{8}/ap-northeast-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=[0-9a-f]{64}$`)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !credential.MatchString(r.Header.Get("Authorization")) || r.Header.Get("X-Amz-Date") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.EscapedPath() != "/bucket/images/a%20b.png" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(png)
+	}))
+	defer server.Close()
+
+	o := &options.Options{
+		S3Region:          "ap-northeast-1",
+		S3Endpoint:        server.URL,
+		S3AccessKeyID:     "AKID",
+		S3SecretAccessKey: "secret",
+	}
+	forbidden, err := fetcher.New(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := forbidden.Fetch("s3://bucket/images/a%20b.png"); !reflect.DeepEqual(err, fetcher.NewForbiddenAddressError("127.0.0.1", "127.0.0.1")) {
+		t.Errorf("endpoint on loopback address shouldn't be connected without allowed networks, but got %v", err)
+	}
+
+	if err := o.AllowedNetworks.Set("127.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	f, err := fetcher.New(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename, err := f.Fetch("s3://bucket/images/a%20b.png")
+	if err != nil {
+		t.Fatalf("fail to Fetch: error=%v", err)
+	}
+	f.Clean(filename)
+	if _, err := f.Fetch("s3://bucket/images/c.png"); !reflect.DeepEqual(err, fetcher.NewStatusError("s3://bucket/images/c.png", http.StatusNotFound)) {
+		t.Errorf("missing object should be responded with 404, but got %v", err)
+	}
+	for _, c := range []struct {
+		url    string
+		bucket string
+	}{
+		{"s3://b/a.png", "b"},
+		{"s3://Bucket/a.png", "Bucket"},
+		{"s3://bucket:80/a.png", "bucket:80"},
+		{"s3://bucket_1/a.png", "bucket_1"},
+		{"s3://-bucket/a.png", "-bucket"},
+		{"s3://my..bucket/a.png", "my..bucket"},
+		{"s3://my.-bucket/a.png", "my.-bucket"},
+		{"s3://192.168.0.1/a.png", "192.168.0.1"},
+		{"s3://user@bucket/a.png", "bucket"},
+	} {
+		if _, err := f.Fetch(c.url); !reflect.DeepEqual(err, fetcher.NewInvalidBucketError(c.bucket)) {
+			t.Errorf("%s: bucket should be invalid, but got %v", c.url, err)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package fetcher
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// fileSource はディレクトリ root 以下のファイルを元画像として読み込む。
+type fileSource struct {
+	root string
+}
+
+func newFileSource(root string) *fileSource {
+	return &fileSource{root: filepath.Clean(root)}
+}
+
+// Open は URL u のパスを root からの相対パスとしてファイルを開く。
+// ファイルがなければ FileNotFoundError を返す。
+// シンボリックリンクを辿った先が root の外であれば開かずに ForbiddenFileError を返す。
+func (s *fileSource) Open(ctx context.Context, u *url.URL) (io.ReadCloser, error) {
+	if u.Host != "" && u.Host != "localhost" {
+		return nil, NewFileNotFoundError(u.String())
+	}
+	root, err := filepath.EvalSymlinks(s.root)
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Join(root, filepath.FromSlash(path.Clean("/"+u.Path)))
+	name, err = filepath.EvalSymlinks(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, NewFileNotFoundError(u.String())
+		}
+		return nil, err
+	}
+	// root が "/" のように区切り文字で終わる場合はそのまま接頭辞にする
+	prefix := root
+	if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+		prefix += string(filepath.Separator)
+	}
+	if !strings.HasPrefix(name, prefix) {
+		return nil, NewForbiddenFileError(u.String())
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, NewFileNotFoundError(u.String())
+	}
+	return os.Open(name)
+}
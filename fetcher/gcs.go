@@ -0,0 +1,78 @@
+package fetcher
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	gcs "cloud.google.com/go/storage"
+	"github.com/minodisk/resizer/options"
+	"github.com/pkg/errors"
+	opt "google.golang.org/api/option"
+)
+
+// gcsSource は Google Cloud Storage のオブジェクトを元画像として読み込む。
+// URL は gs://<バケット>/<オブジェクト> の形式で指定する。
+type gcsSource struct {
+	account string
+	timeout time.Duration
+
+	once   sync.Once
+	client *gcs.Client
+	err    error
+}
+
+func newGCSSource(o *options.Options) *gcsSource {
+	return &gcsSource{
+		account: o.ServiceAccount.Path,
+		timeout: o.FetchTimeout,
+	}
+}
+
+// Open は Context ctx でオブジェクトを読み込む ReadCloser を返す。
+// クライアントは最初に使用する際に、リサイズ画像のアップロードと同じサービスアカウントで作成する。
+// クライアントは以降のリクエストでも使用するため、ctx ではなく Background で作成する。
+func (s *gcsSource) Open(ctx context.Context, u *url.URL) (io.ReadCloser, error) {
+	s.once.Do(func() {
+		opts := []opt.ClientOption{opt.WithScopes(gcs.ScopeReadOnly)}
+		if s.account != "" {
+			opts = append(opts, opt.WithServiceAccountFile(s.account))
+		}
+		s.client, s.err = gcs.NewClient(context.Background(), opts...)
+	})
+	if s.err != nil {
+		return nil, errors.Wrap(s.err, "can't create client for GCS")
+	}
+
+	cancel := context.CancelFunc(func() {})
+	if s.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+	}
+	r, err := s.client.Bucket(u.Host).Object(strings.TrimPrefix(u.Path, "/")).NewReader(ctx)
+	if err != nil {
+		cancel()
+		if err == gcs.ErrObjectNotExist || err == gcs.ErrBucketNotExist {
+			return nil, NewStatusError(u.String(), http.StatusNotFound)
+		}
+		if err == context.DeadlineExceeded {
+			return nil, NewTimeoutError(u.String())
+		}
+		return nil, errors.Wrapf(err, "fail to read %s", u)
+	}
+	return &cancelReadCloser{r, cancel}, nil
+}
+
+// cancelReadCloser は Close した時にコンテキストをキャンセルする。
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *cancelReadCloser) Close() error {
+	defer r.cancel()
+	return r.ReadCloser.Close()
+}
@@ -0,0 +1,185 @@
+package fetcher
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/minodisk/resizer/logger"
+	"github.com/minodisk/resizer/options"
+	"github.com/pkg/errors"
+)
+
+const (
+	s3Service       = "s3"
+	s3DefaultRegion = "us-east-1"
+	// emptyPayloadHash は空のボディの SHA-256 のハッシュ値。
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// bucketPattern は S3 のバケット名の規則に従う名前に一致する。
+// 3 文字以上 63 文字以下の小文字の英数字、ピリオド、ハイフンからなり、英数字で始まり英数字で終わる。
+var bucketPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// s3Source は Amazon S3 または S3 互換のストレージのオブジェクトを元画像として読み込む。
+// URL は s3://<バケット>/<オブジェクト> の形式で指定する。
+// アクセスキーが指定されている場合は署名バージョン 4 でリクエストに署名する。
+type s3Source struct {
+	region          string
+	endpoint        string
+	accessKeyID     string
+	secretAccessKey string
+	now             func() time.Time
+}
+
+func newS3Source(o *options.Options) *s3Source {
+	s := &s3Source{
+		region:          o.S3Region,
+		endpoint:        strings.TrimSuffix(o.S3Endpoint, "/"),
+		accessKeyID:     o.S3AccessKeyID,
+		secretAccessKey: string(o.S3SecretAccessKey),
+		now:             time.Now,
+	}
+	if s.region == "" {
+		s.region = s3DefaultRegion
+	}
+	return s
+}
+
+// fetchS3 は URL u のオブジェクトを取得してファイルに保存し、そのパスを返す。
+// リクエストは HTTP の元画像と同じクライアントで送信し、接続先のアドレスとサイズを検査する。
+func (f *Fetcher) fetchS3(u *url.URL) (string, error) {
+	req, err := f.s3.newRequest(u)
+	if err != nil {
+		return "", err
+	}
+	sum := md5.Sum([]byte(fmt.Sprintf("%s-%d", u, time.Now().UnixNano())))
+	filename := path.Join(tempDir, fmt.Sprintf("%x", sum))
+	logger.FromContext(f.ctx).Debug("save source image temporarily", "url", u, "filename", filename)
+
+	if _, err := f.download(req.WithContext(f.ctx), filename); err != nil {
+		os.Remove(filename)
+		// エンドポイントの URL ではなく s3 スキームの URL で報告する
+		switch e := err.(type) {
+		case StatusError:
+			return "", NewStatusError(u.String(), e.StatusCode)
+		case TimeoutError:
+			return "", NewTimeoutError(u.String())
+		case TooLargeError:
+			return "", NewTooLargeError(u.String(), e.Max)
+		}
+		return "", err
+	}
+	return filename, nil
+}
+
+// newRequest は URL u のオブジェクトを GET するリクエストを作成する。
+// バケット名が S3 の規則に従わない場合は InvalidBucketError を返す。
+func (s *s3Source) newRequest(u *url.URL) (*http.Request, error) {
+	if u.User != nil || !validBucket(u.Host) {
+		return nil, NewInvalidBucketError(u.Host)
+	}
+	req, err := http.NewRequest("GET", s.objectURL(u.Host, strings.TrimPrefix(u.Path, "/")), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to new request")
+	}
+	if s.accessKeyID != "" {
+		s.sign(req, s.now())
+	}
+	return req, nil
+}
+
+// validBucket はバケット名 name が S3 の規則に従うかを判定する。
+// IP アドレスの形式の名前や、ピリオドとハイフンが隣り合う名前は規則に従わない。
+func validBucket(name string) bool {
+	return bucketPattern.MatchString(name) &&
+		net.ParseIP(name) == nil &&
+		!strings.Contains(name, "..") &&
+		!strings.Contains(name, ".-") &&
+		!strings.Contains(name, "-.")
+}
+
+// objectURL はバケット bucket のオブジェクト key の URL を返す。
+// エンドポイントが指定されている場合はパス形式の URL を返す。
+func (s *s3Source) objectURL(bucket, key string) string {
+	if s.endpoint != "" {
+		return fmt.Sprintf("%s/%s/%s", s.endpoint, bucket, escapeS3Path(key))
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, s.region, escapeS3Path(key))
+}
+
+// sign はリクエスト req に時刻 t の署名バージョン 4 の署名を付加する。
+func (s *s3Source) sign(req *http.Request, t time.Time) {
+	amzDate := t.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + emptyPayloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+	scope := strings.Join([]string{date, s.region, s3Service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256(canonicalRequest),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretAccessKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, s3Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+// escapeS3Path は S3 の署名の仕様に従って、非予約文字とスラッシュ以外をエスケープする。
+func escapeS3Path(p string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexSHA256(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
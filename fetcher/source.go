@@ -0,0 +1,62 @@
+package fetcher
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"time"
+
+	"github.com/minodisk/resizer/logger"
+	"github.com/minodisk/resizer/options"
+	"github.com/pkg/errors"
+)
+
+// Source は HTTP 以外のスキームの元画像の取得元。
+type Source interface {
+	// Open は Context ctx で URL u の元画像のデータを読み込む ReadCloser を返す。
+	Open(ctx context.Context, u *url.URL) (io.ReadCloser, error)
+}
+
+// newSources はオプション o からスキームをキーにした取得元の一覧を作成する。
+// s3 スキームは HTTP のクライアントで取得するため、一覧には含めない。
+// file スキームは元画像のディレクトリが指定されている場合のみ使用できる。
+func newSources(o *options.Options) map[string]Source {
+	sources := map[string]Source{
+		"gs": newGCSSource(o),
+	}
+	if o.SourceRoot != "" {
+		sources["file"] = newFileSource(o.SourceRoot)
+	}
+	return sources
+}
+
+// fetchSource は取得元 s から URL u の元画像を取得してファイルに保存し、そのパスを返す。
+func (f *Fetcher) fetchSource(s Source, u *url.URL) (string, error) {
+	sum := md5.Sum([]byte(fmt.Sprintf("%s-%d", u, time.Now().UnixNano())))
+	filename := path.Join(tempDir, fmt.Sprintf("%x", sum))
+	l := logger.FromContext(f.ctx)
+	l.Debug("save source image temporarily", "url", u, "filename", filename)
+
+	r, err := s.Open(f.ctx, u)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := r.Close(); err != nil {
+			l.Warn("fail to close source", "url", u, "err", err)
+		}
+	}()
+	if err := f.store(r, u.String(), filename); err != nil {
+		os.Remove(filename)
+		switch e := err.(type) {
+		case InvalidContentTypeError, TooLargeError:
+			return "", e
+		}
+		return "", errors.Wrapf(err, "fail to read %s", u)
+	}
+	return filename, nil
+}
@@ -0,0 +1,89 @@
+// Package flight では同一キーの処理を同時に一度だけ実行する仕組みが実装されています。
+//
+// 同じ画像に対するリサイズのリクエストが同時に届いた場合に、
+// 取得・デコード・リサイズを一度だけ行い結果を共有するために使います。
+package flight
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+var errPanicked = errors.New("flight: the function panicked")
+
+type call struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+}
+
+// Stats は Group の処理回数を表す。
+type Stats struct {
+	// Leads は実際に処理を実行した回数。
+	Leads int64
+	// Shares は実行中または保持中の処理の結果を共有した回数。
+	Shares int64
+}
+
+// Group は同一キーの処理をまとめる。ゼロ値で使用できる。
+type Group struct {
+	leads  int64
+	shares int64
+	mu     sync.Mutex
+	calls  map[string]*call
+}
+
+// Do はキー key の処理 fn を実行し、その結果を返す。
+// 同じキーの処理が実行中または保持中であれば fn を実行せずにその結果を待って返す。
+// shared は結果が他の呼び出しと共有されたものかどうかを表す。
+//
+// fn がエラーなく完了した場合、その結果は Forget が呼ばれるまで保持される。
+// shared が false の呼び出し元は、結果が不要になった時点で Forget を呼ばなければならない。
+func (g *Group) Do(key string, fn func() (interface{}, error)) (val interface{}, shared bool, err error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		atomic.AddInt64(&g.shares, 1)
+		c.wg.Wait()
+		return c.val, true, c.err
+	}
+	c := new(call)
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+	atomic.AddInt64(&g.leads, 1)
+
+	completed := false
+	defer func() {
+		if !completed {
+			c.err = errPanicked
+		}
+		if c.err != nil {
+			g.Forget(key)
+		}
+		c.wg.Done()
+	}()
+	c.val, c.err = fn()
+	completed = true
+	return c.val, false, c.err
+}
+
+// Forget は保持しているキー key の結果を破棄する。
+// 以降の同じキーの Do では再び処理が実行される。
+func (g *Group) Forget(key string) {
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+}
+
+// Stats は処理回数を返す。
+func (g *Group) Stats() Stats {
+	return Stats{
+		Leads:  atomic.LoadInt64(&g.leads),
+		Shares: atomic.LoadInt64(&g.shares),
+	}
+}
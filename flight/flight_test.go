@@ -0,0 +1,93 @@
+package flight_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minodisk/resizer/flight"
+)
+
+func TestDo(t *testing.T) {
+	t.Parallel()
+
+	var g flight.Group
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	results := make(chan bool, 10)
+	calls := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			v, shared, err := g.Do("foo", func() (interface{}, error) {
+				calls++
+				time.Sleep(50 * time.Millisecond)
+				return "bar", nil
+			})
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if v != "bar" {
+				t.Errorf("got: %v, want: bar", v)
+			}
+			results <- shared
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(results)
+
+	if calls != 1 {
+		t.Errorf("function should be called once, but called %d times", calls)
+	}
+	leads := 0
+	for shared := range results {
+		if !shared {
+			leads++
+		}
+	}
+	if leads != 1 {
+		t.Errorf("only one call should lead, but %d calls led", leads)
+	}
+	if got, want := g.Stats(), (flight.Stats{Leads: 1, Shares: 9}); got != want {
+		t.Errorf("stats\n got: %+v\nwant: %+v", got, want)
+	}
+}
+
+func TestDoHoldsResultUntilForget(t *testing.T) {
+	t.Parallel()
+
+	var g flight.Group
+	calls := 0
+	fn := func() (interface{}, error) {
+		calls++
+		return calls, nil
+	}
+	if _, shared, _ := g.Do("foo", fn); shared {
+		t.Errorf("1st call shouldn't be shared")
+	}
+	if v, shared, _ := g.Do("foo", fn); !shared || v != 1 {
+		t.Errorf("2nd call should share the held result: got %v, shared=%t", v, shared)
+	}
+	g.Forget("foo")
+	if v, shared, _ := g.Do("foo", fn); shared || v != 2 {
+		t.Errorf("call after Forget should run the function: got %v, shared=%t", v, shared)
+	}
+}
+
+func TestDoDoesNotHoldError(t *testing.T) {
+	t.Parallel()
+
+	var g flight.Group
+	e := errors.New("fail")
+	if _, _, err := g.Do("foo", func() (interface{}, error) { return nil, e }); err != e {
+		t.Errorf("got: %v, want: %v", err, e)
+	}
+	v, shared, err := g.Do("foo", func() (interface{}, error) { return "bar", nil })
+	if shared || err != nil || v != "bar" {
+		t.Errorf("call after error should run the function: got %v, shared=%t, err=%v", v, shared, err)
+	}
+}
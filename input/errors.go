@@ -74,3 +74,83 @@ func NewInvalidQualityError(quality int) InvalidQualityError {
 func (err InvalidQualityError) Error() string {
 	return fmt.Sprintf("quality %d isn't allowed", err.Quality)
 }
+
+type UnknownPresetError struct {
+	Preset string
+}
+
+func NewUnknownPresetError(preset string) UnknownPresetError {
+	return UnknownPresetError{preset}
+}
+
+func (err UnknownPresetError) Error() string {
+	return fmt.Sprintf("preset '%s' isn't defined", err.Preset)
+}
+
+type PresetsOnlyError struct{}
+
+func NewPresetsOnlyError() PresetsOnlyError {
+	return PresetsOnlyError{}
+}
+
+func (err PresetsOnlyError) Error() string {
+	return "only presets are allowed"
+}
+
+type InvalidPathError struct {
+	Path string
+}
+
+func NewInvalidPathError(path string) InvalidPathError {
+	return InvalidPathError{path}
+}
+
+func (err InvalidPathError) Error() string {
+	return fmt.Sprintf("path '%s' isn't valid", err.Path)
+}
+
+type DuplicatedParameterError struct {
+	Key string
+}
+
+func NewDuplicatedParameterError(key string) DuplicatedParameterError {
+	return DuplicatedParameterError{key}
+}
+
+func (err DuplicatedParameterError) Error() string {
+	return fmt.Sprintf("parameter '%s' is specified in both of the path and the query", err.Key)
+}
+
+type UnknownOriginError struct {
+	Origin string
+}
+
+func NewUnknownOriginError(origin string) UnknownOriginError {
+	return UnknownOriginError{origin}
+}
+
+func (err UnknownOriginError) Error() string {
+	return fmt.Sprintf("origin '%s' isn't defined", err.Origin)
+}
+
+type InvalidOriginPathError struct {
+	Path string
+}
+
+func NewInvalidOriginPathError(path string) InvalidOriginPathError {
+	return InvalidOriginPathError{path}
+}
+
+func (err InvalidOriginPathError) Error() string {
+	return fmt.Sprintf("path '%s' in origin isn't allowed", err.Path)
+}
+
+type ConflictedSourceError struct{}
+
+func NewConflictedSourceError() ConflictedSourceError {
+	return ConflictedSourceError{}
+}
+
+func (err ConflictedSourceError) Error() string {
+	return "url and origin shouldn't be specified together"
+}
@@ -3,13 +3,18 @@ package input
 import (
 	"fmt"
 	"net/url"
+	"path"
 	"strconv"
+	"strings"
 
 	"github.com/minodisk/resizer/options"
 )
 
 const (
 	KeyURL     = "url"
+	KeyOrigin  = "origin"
+	KeyPath    = "path"
+	KeyPreset  = "preset"
 	KeyMethod  = "method"
 	KeyWidth   = "width"
 	KeyHeight  = "height"
@@ -34,11 +39,23 @@ var (
 	allowedSchemes = []string{
 		"http",
 		"https",
+		"gs",
+		"s3",
+		"file",
+	}
+	presetKeys = []string{
+		KeyMethod,
+		KeyWidth,
+		KeyHeight,
+		KeyFormat,
+		KeyQuality,
 	}
 )
 
 type Input struct {
 	URL     string
+	Origin  string
+	Preset  string
 	Method  string
 	Width   int
 	Height  int
@@ -46,41 +63,104 @@ type Input struct {
 	Quality int
 }
 
-func New(q map[string][]string) (Input, error) {
-	var o Input
+// New はクエリのマップ q から Input を作成する。
+// preset が指定されている場合はプリセットの値を適用した上で、明示されたパラメーターで上書きする。
+// プリセットのみに制限されている場合は、preset の指定を必須とし、パラメーターでの上書きを許可しない。
+// origin が指定されている場合は、取得先の URL に path を連結して元画像の URL とする。
+func New(q map[string][]string, o *options.Options) (Input, error) {
+	var i Input
 	if len(q[KeyURL]) != 0 {
-		o.URL = q[KeyURL][0]
+		i.URL = q[KeyURL][0]
+	}
+	if len(q[KeyOrigin]) != 0 {
+		i.Origin = q[KeyOrigin][0]
+		if i.URL != "" {
+			return i, NewConflictedSourceError()
+		}
+		var p string
+		if len(q[KeyPath]) != 0 {
+			p = q[KeyPath][0]
+		}
+		u, err := resolveOrigin(o.Origins, i.Origin, p)
+		if err != nil {
+			return i, err
+		}
+		i.URL = u
+	}
+	if len(q[KeyPreset]) != 0 {
+		i.Preset = q[KeyPreset][0]
+	}
+	if o.PresetsOnly {
+		if i.Preset == "" {
+			return i, NewPresetsOnlyError()
+		}
+		for _, k := range presetKeys {
+			if len(q[k]) != 0 {
+				return i, NewPresetsOnlyError()
+			}
+		}
 	}
+	if i.Preset != "" {
+		p, ok := o.Presets[i.Preset]
+		if !ok {
+			return i, NewUnknownPresetError(i.Preset)
+		}
+		i.Method = p.Method
+		i.Width = p.Width
+		i.Height = p.Height
+		i.Format = p.Format
+		i.Quality = p.Quality
+	}
+
 	if len(q[KeyMethod]) != 0 {
-		o.Method = q[KeyMethod][0]
+		i.Method = q[KeyMethod][0]
 	}
 	if len(q[KeyWidth]) != 0 {
 		w, err := strconv.Atoi(q[KeyWidth][0])
 		if err != nil {
-			return o, err
+			return i, err
 		}
-		o.Width = w
+		i.Width = w
 	}
 	if len(q[KeyHeight]) != 0 {
 		h, err := strconv.Atoi(q[KeyHeight][0])
 		if err != nil {
-			return o, err
+			return i, err
 		}
-		o.Height = h
+		i.Height = h
 	}
 	if len(q[KeyFormat]) != 0 {
-		o.Format = q[KeyFormat][0]
+		i.Format = q[KeyFormat][0]
 	}
 	if len(q[KeyQuality]) != 0 {
 		var err error
-		o.Quality, err = strconv.Atoi(q[KeyQuality][0])
+		i.Quality, err = strconv.Atoi(q[KeyQuality][0])
 		if err != nil {
-			return o, err
+			return i, err
 		}
-	} else {
-		o.Quality = 100
+	} else if i.Quality == 0 {
+		i.Quality = 100
 	}
-	return o, nil
+	return i, nil
+}
+
+// resolveOrigin は名前 name の取得先の URL にパス p を連結した URL を返す。
+// パスは取得先の URL の外を参照できないように正規化する。
+func resolveOrigin(origins options.Origins, name, p string) (string, error) {
+	origin, ok := origins[name]
+	if !ok {
+		return "", NewUnknownOriginError(name)
+	}
+	if p == "" || strings.ContainsAny(p, "\\\x00") {
+		return "", NewInvalidOriginPathError(p)
+	}
+	cleaned := path.Clean("/" + p)
+	if cleaned == "/" {
+		return "", NewInvalidOriginPathError(p)
+	}
+	u := origin.Base()
+	u.Path += cleaned[1:]
+	return u.String(), nil
 }
 
 func (i Input) Validate(allowedHosts options.Hosts) (Input, error) {
@@ -115,8 +195,23 @@ func (i Input) ValidateURL(allowedHosts options.Hosts) (Input, error) {
 	if !in(u.Scheme, allowedSchemes) {
 		return i, NewInvalidSchemeError(u.Scheme)
 	}
-	if !allowedHosts.Contains(u.Host) {
-		return i, NewInvalidHostError(u.Host)
+	switch u.Scheme {
+	case "file":
+		// ファイルは設定されたディレクトリ以下から読み込むため、ホストを指定させない
+		if u.Host != "" && u.Host != "localhost" {
+			return i, NewInvalidHostError(u.Host)
+		}
+	case "gs", "s3":
+		// 任意のバケットを読み込ませないように、バケットは明示的に許可されている必要がある
+		// 取得先は http と https に限るため、取得先が指定されていても検査する
+		if len(allowedHosts) == 0 || !allowedHosts.Match(u) {
+			return i, NewInvalidHostError(u.Host)
+		}
+	default:
+		// 取得先が指定された URL は設定されたホストを参照するため、許可されたホストかを検査しない
+		if i.Origin == "" && !allowedHosts.Match(u) {
+			return i, NewInvalidHostError(u.Host)
+		}
 	}
 	return i, nil
 }
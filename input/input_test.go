@@ -5,8 +5,229 @@ import (
 	"testing"
 
 	"github.com/minodisk/resizer/input"
+	"github.com/minodisk/resizer/options"
 )
 
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	var origins options.Origins
+	if err := origins.Set("products=https://assets.example.com/products/"); err != nil {
+		t.Fatal(err)
+	}
+
+	presets := options.Presets{
+		"thumb": options.Preset{
+			Width:   320,
+			Height:  320,
+			Method:  input.MethodCover,
+			Format:  input.FormatJPEG,
+			Quality: 80,
+		},
+	}
+	for _, c := range []struct {
+		name    string
+		query   map[string][]string
+		options options.Options
+		want    input.Input
+		err     error
+	}{
+		{
+			"without preset",
+			map[string][]string{
+				"url":   {"http://example.com/a.jpg"},
+				"width": {"100"},
+			},
+			options.Options{},
+			input.Input{
+				URL:     "http://example.com/a.jpg",
+				Width:   100,
+				Quality: 100,
+			},
+			nil,
+		},
+		{
+			"with preset",
+			map[string][]string{
+				"url":    {"http://example.com/a.jpg"},
+				"preset": {"thumb"},
+			},
+			options.Options{
+				Presets: presets,
+			},
+			input.Input{
+				URL:     "http://example.com/a.jpg",
+				Preset:  "thumb",
+				Width:   320,
+				Height:  320,
+				Method:  input.MethodCover,
+				Format:  input.FormatJPEG,
+				Quality: 80,
+			},
+			nil,
+		},
+		{
+			"with preset overridden by parameters",
+			map[string][]string{
+				"url":     {"http://example.com/a.jpg"},
+				"preset":  {"thumb"},
+				"width":   {"640"},
+				"quality": {"90"},
+			},
+			options.Options{
+				Presets: presets,
+			},
+			input.Input{
+				URL:     "http://example.com/a.jpg",
+				Preset:  "thumb",
+				Width:   640,
+				Height:  320,
+				Method:  input.MethodCover,
+				Format:  input.FormatJPEG,
+				Quality: 90,
+			},
+			nil,
+		},
+		{
+			"with unknown preset",
+			map[string][]string{
+				"url":    {"http://example.com/a.jpg"},
+				"preset": {"foo"},
+			},
+			options.Options{
+				Presets: presets,
+			},
+			input.Input{
+				URL:    "http://example.com/a.jpg",
+				Preset: "foo",
+			},
+			input.NewUnknownPresetError("foo"),
+		},
+		{
+			"without preset when presets only",
+			map[string][]string{
+				"url":   {"http://example.com/a.jpg"},
+				"width": {"100"},
+			},
+			options.Options{
+				Presets:     presets,
+				PresetsOnly: true,
+			},
+			input.Input{
+				URL: "http://example.com/a.jpg",
+			},
+			input.NewPresetsOnlyError(),
+		},
+		{
+			"with preset overridden by parameters when presets only",
+			map[string][]string{
+				"url":    {"http://example.com/a.jpg"},
+				"preset": {"thumb"},
+				"width":  {"640"},
+			},
+			options.Options{
+				Presets:     presets,
+				PresetsOnly: true,
+			},
+			input.Input{
+				URL:    "http://example.com/a.jpg",
+				Preset: "thumb",
+			},
+			input.NewPresetsOnlyError(),
+		},
+		{
+			"with origin",
+			map[string][]string{
+				"origin": {"products"},
+				"path":   {"/123/a b.jpg"},
+				"width":  {"100"},
+			},
+			options.Options{
+				Origins: origins,
+			},
+			input.Input{
+				URL:     "https://assets.example.com/products/123/a%20b.jpg",
+				Origin:  "products",
+				Width:   100,
+				Quality: 100,
+			},
+			nil,
+		},
+		{
+			"with origin and path escaping the origin",
+			map[string][]string{
+				"origin": {"products"},
+				"path":   {"../../users/1.jpg?x=1"},
+			},
+			options.Options{
+				Origins: origins,
+			},
+			input.Input{
+				URL:     "https://assets.example.com/products/users/1.jpg%3Fx=1",
+				Origin:  "products",
+				Quality: 100,
+			},
+			nil,
+		},
+		{
+			"with origin and empty path",
+			map[string][]string{
+				"origin": {"products"},
+				"path":   {"/../"},
+			},
+			options.Options{
+				Origins: origins,
+			},
+			input.Input{
+				Origin: "products",
+			},
+			input.NewInvalidOriginPathError("/../"),
+		},
+		{
+			"with unknown origin",
+			map[string][]string{
+				"origin": {"users"},
+				"path":   {"/1.jpg"},
+			},
+			options.Options{
+				Origins: origins,
+			},
+			input.Input{
+				Origin: "users",
+			},
+			input.NewUnknownOriginError("users"),
+		},
+		{
+			"with origin and url",
+			map[string][]string{
+				"url":    {"http://example.com/a.jpg"},
+				"origin": {"products"},
+				"path":   {"/1.jpg"},
+			},
+			options.Options{
+				Origins: origins,
+			},
+			input.Input{
+				URL:    "http://example.com/a.jpg",
+				Origin: "products",
+			},
+			input.NewConflictedSourceError(),
+		},
+	} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := input.New(c.query, &c.options)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("result\n got: %+v\nwant: %+v", got, c.want)
+			}
+			if !reflect.DeepEqual(err, c.err) {
+				t.Errorf("error\n got: %+v\nwant: %+v", err, c.err)
+			}
+		})
+	}
+}
+
 func TestValidateURL(t *testing.T) {
 	t.Parallel()
 
@@ -57,6 +278,56 @@ func TestValidateURL(t *testing.T) {
 			},
 			input.NewInvalidSchemeError("ftp"),
 		},
+		{
+			"allow gs with specified bucket",
+			input.Input{
+				URL: "gs://bucket/a.jpg",
+			},
+			[]string{
+				"bucket",
+			},
+			input.Input{
+				URL: "gs://bucket/a.jpg",
+			},
+			nil,
+		},
+		{
+			"not allow s3 without specified buckets",
+			input.Input{
+				URL: "s3://bucket/a.jpg",
+			},
+			[]string{},
+			input.Input{
+				URL: "s3://bucket/a.jpg",
+			},
+			input.NewInvalidHostError("bucket"),
+		},
+		{
+			"allow file without hosts",
+			input.Input{
+				URL: "file:///images/a.jpg",
+			},
+			[]string{
+				"example.com",
+			},
+			input.Input{
+				URL: "file:///images/a.jpg",
+			},
+			nil,
+		},
+		{
+			"not allow file with host",
+			input.Input{
+				URL: "file://example.com/images/a.jpg",
+			},
+			[]string{
+				"example.com",
+			},
+			input.Input{
+				URL: "file://example.com/images/a.jpg",
+			},
+			input.NewInvalidHostError("example.com"),
+		},
 		{
 			"not allow unspecified hosts",
 			input.Input{
@@ -97,6 +368,19 @@ func TestValidateURL(t *testing.T) {
 			},
 			input.NewInvalidHostError("example.com:8080"),
 		},
+		{
+			"Can specify hosts with wildcard",
+			input.Input{
+				URL: "http://img1.example.com",
+			},
+			[]string{
+				"*.example.com",
+			},
+			input.Input{
+				URL: "http://img1.example.com",
+			},
+			nil,
+		},
 		{
 			"Can specify host with port",
 			input.Input{
@@ -114,7 +398,7 @@ func TestValidateURL(t *testing.T) {
 		c := c
 		t.Run(c.name, func(t *testing.T) {
 			t.Parallel()
-			got, err := c.input.ValidateURL(c.hosts)
+			got, err := c.input.ValidateURL(options.MustHosts(c.hosts...))
 			if !reflect.DeepEqual(got, c.want) {
 				t.Errorf("result\n got: %+v\nwant: %+v", got, c.want)
 			}
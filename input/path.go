@@ -0,0 +1,95 @@
+package input
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	pathKeys = map[byte]string{
+		'p': KeyPreset,
+		'm': KeyMethod,
+		'w': KeyWidth,
+		'h': KeyHeight,
+		'f': KeyFormat,
+		'q': KeyQuality,
+	}
+	// CDN やプロキシによって連続したスラッシュがまとめられる場合がある
+	schemeRegexp = regexp.MustCompile(`^(https?):/+`)
+)
+
+// ParsePath はエスケープされたパス p で指定されたパラメーターをクエリのマップ q に加えて返す。
+// パスは以下のいずれかの形式で指定する。
+//
+//   /w_800,h_600,m_cover,f_png,q_80,p_thumb/<エスケープした元画像のURL>
+//   /<プリセット>/<ホスト>/<パス>
+//
+// 元画像のURLの代わりに <ホスト>/<パス> を指定した場合、スキームは https とする。
+// パスが / の場合は q をそのまま返す。
+func ParsePath(p string, q map[string][]string) (map[string][]string, error) {
+	path := strings.TrimPrefix(p, "/")
+	if path == "" {
+		return q, nil
+	}
+	i := strings.Index(path, "/")
+	if i == -1 {
+		return nil, NewInvalidPathError(p)
+	}
+	seg, rest := path[:i], path[i+1:]
+	// パスで指定した場合はクエリでのリサイズのパラメーターの指定を許可しない
+	for _, k := range append([]string{KeyURL, KeyOrigin, KeyPath, KeyPreset}, presetKeys...) {
+		if len(q[k]) != 0 {
+			return nil, NewDuplicatedParameterError(k)
+		}
+	}
+
+	params := map[string]string{}
+	if isOptionsSegment(seg) {
+		for _, token := range strings.Split(seg, ",") {
+			v, err := url.PathUnescape(token[2:])
+			if err != nil || v == "" {
+				return nil, NewInvalidPathError(p)
+			}
+			params[pathKeys[token[0]]] = v
+		}
+	} else {
+		preset, err := url.PathUnescape(seg)
+		if err != nil || preset == "" {
+			return nil, NewInvalidPathError(p)
+		}
+		params[KeyPreset] = preset
+	}
+
+	u, err := url.PathUnescape(rest)
+	if err != nil || rest == "" {
+		return nil, NewInvalidPathError(p)
+	}
+	if schemeRegexp.MatchString(u) {
+		params[KeyURL] = schemeRegexp.ReplaceAllString(u, "$1://")
+	} else {
+		params[KeyURL] = "https://" + rest
+	}
+
+	r := make(map[string][]string, len(q)+len(params))
+	for k, v := range q {
+		r[k] = v
+	}
+	for k, v := range params {
+		r[k] = []string{v}
+	}
+	return r, nil
+}
+
+// isOptionsSegment はパスのセグメント seg が k_v 形式のパラメーターの並びかを判定する。
+func isOptionsSegment(seg string) bool {
+	for _, token := range strings.Split(seg, ",") {
+		if len(token) < 2 || token[1] != '_' {
+			return false
+		}
+		if _, ok := pathKeys[token[0]]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,127 @@
+package input_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/minodisk/resizer/input"
+)
+
+func TestParsePath(t *testing.T) {
+	t.Parallel()
+
+	for _, c := range []struct {
+		name  string
+		path  string
+		query map[string][]string
+		want  map[string][]string
+		err   error
+	}{
+		{
+			"root",
+			"/",
+			map[string][]string{
+				"url":   {"http://example.com/a.jpg"},
+				"width": {"100"},
+			},
+			map[string][]string{
+				"url":   {"http://example.com/a.jpg"},
+				"width": {"100"},
+			},
+			nil,
+		},
+		{
+			"options and escaped URL",
+			"/w_800,h_600,m_cover,f_png,q_80/http%3A%2F%2Fexample.com%2Fa.jpg%3Fv%3D1",
+			map[string][]string{},
+			map[string][]string{
+				"url":     {"http://example.com/a.jpg?v=1"},
+				"width":   {"800"},
+				"height":  {"600"},
+				"method":  {"cover"},
+				"format":  {"png"},
+				"quality": {"80"},
+			},
+			nil,
+		},
+		{
+			"options and unescaped URL",
+			"/w_800/https://example.com/images/a.jpg",
+			map[string][]string{},
+			map[string][]string{
+				"url":   {"https://example.com/images/a.jpg"},
+				"width": {"800"},
+			},
+			nil,
+		},
+		{
+			"options and URL with merged slashes",
+			"/w_800/https:/example.com/a.jpg",
+			map[string][]string{},
+			map[string][]string{
+				"url":   {"https://example.com/a.jpg"},
+				"width": {"800"},
+			},
+			nil,
+		},
+		{
+			"options with preset and host",
+			"/p_thumb,q_90/example.com/a.jpg",
+			map[string][]string{},
+			map[string][]string{
+				"url":     {"https://example.com/a.jpg"},
+				"preset":  {"thumb"},
+				"quality": {"90"},
+			},
+			nil,
+		},
+		{
+			"preset and host",
+			"/thumb/example.com/images/a%20b.jpg",
+			map[string][]string{
+				"signature": {"abc"},
+			},
+			map[string][]string{
+				"url":       {"https://example.com/images/a%20b.jpg"},
+				"preset":    {"thumb"},
+				"signature": {"abc"},
+			},
+			nil,
+		},
+		{
+			"without URL",
+			"/w_800",
+			map[string][]string{},
+			nil,
+			input.NewInvalidPathError("/w_800"),
+		},
+		{
+			"with empty value",
+			"/w_/example.com/a.jpg",
+			map[string][]string{},
+			nil,
+			input.NewInvalidPathError("/w_/example.com/a.jpg"),
+		},
+		{
+			"duplicated parameter",
+			"/w_800/example.com/a.jpg",
+			map[string][]string{
+				"width": {"100"},
+			},
+			nil,
+			input.NewDuplicatedParameterError("width"),
+		},
+	} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := input.ParsePath(c.path, c.query)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("result\n got: %+v\nwant: %+v", got, c.want)
+			}
+			if !reflect.DeepEqual(err, c.err) {
+				t.Errorf("error\n got: %+v\nwant: %+v", err, c.err)
+			}
+		})
+	}
+}
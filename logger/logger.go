@@ -0,0 +1,242 @@
+// Package logger では構造化されたログの出力が実装されています。
+//
+// ログは 1 行ごとに JSON または logfmt の形式で出力し、
+// リクエストごとの ID などのフィールドは context.Context を通して引き継ぎます。
+package logger
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// ログの形式。
+const (
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+)
+
+// Level はログのレベル。
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return strconv.Itoa(int(l))
+}
+
+// Logger はレベル以上のログを出力する。
+// 複数の goroutine から同時に使用できる。
+type Logger struct {
+	out    *output
+	format string
+	level  Level
+	// fields は全てのログに付加するキーと値の組。
+	fields []interface{}
+}
+
+type output struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// New は w に形式 format でレベル level 以上のログを出力する Logger を作成する。
+// format が FormatJSON 以外の場合は logfmt で出力する。
+func New(w io.Writer, format string, level Level) *Logger {
+	return &Logger{
+		out:    &output{w: w},
+		format: format,
+		level:  level,
+	}
+}
+
+var (
+	defaultMu     sync.RWMutex
+	defaultLogger = New(os.Stderr, FormatLogfmt, LevelInfo)
+)
+
+// Default はデフォルトの Logger を返す。
+func Default() *Logger {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultLogger
+}
+
+// SetDefault はデフォルトの Logger を l にする。
+func SetDefault(l *Logger) {
+	defaultMu.Lock()
+	defaultLogger = l
+	defaultMu.Unlock()
+}
+
+type contextKey struct{}
+
+// NewContext は Logger l を持つ Context を返す。
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext は ctx の Logger を返す。
+// ctx が Logger を持っていない場合はデフォルトの Logger を返す。
+func FromContext(ctx context.Context) *Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+			return l
+		}
+	}
+	return Default()
+}
+
+// NewRequestID はリクエストを識別するランダムな ID を返す。
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// With はキーと値の組 kv を全てのログに付加する Logger を返す。
+func (l *Logger) With(kv ...interface{}) *Logger {
+	c := *l
+	c.fields = append(append([]interface{}(nil), l.fields...), kv...)
+	return &c
+}
+
+// Enabled はレベル level のログを出力するかを返す。
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+// Debug はメッセージ msg とキーと値の組 kv をデバッグのレベルで出力する。
+func (l *Logger) Debug(msg string, kv ...interface{}) {
+	l.log(LevelDebug, msg, kv)
+}
+
+// Info はメッセージ msg とキーと値の組 kv を情報のレベルで出力する。
+func (l *Logger) Info(msg string, kv ...interface{}) {
+	l.log(LevelInfo, msg, kv)
+}
+
+// Warn はメッセージ msg とキーと値の組 kv を警告のレベルで出力する。
+func (l *Logger) Warn(msg string, kv ...interface{}) {
+	l.log(LevelWarn, msg, kv)
+}
+
+// Error はメッセージ msg とキーと値の組 kv をエラーのレベルで出力する。
+func (l *Logger) Error(msg string, kv ...interface{}) {
+	l.log(LevelError, msg, kv)
+}
+
+func (l *Logger) log(level Level, msg string, kv []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	fields := make([]interface{}, 0, 6+len(l.fields)+len(kv))
+	fields = append(fields, "time", time.Now().UTC().Format(time.RFC3339Nano), "level", level.String(), "msg", msg)
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, nil)
+	}
+
+	var buf bytes.Buffer
+	if l.format == FormatJSON {
+		writeJSON(&buf, fields)
+	} else {
+		writeLogfmt(&buf, fields)
+	}
+	buf.WriteByte('\n')
+
+	l.out.mu.Lock()
+	l.out.w.Write(buf.Bytes())
+	l.out.mu.Unlock()
+}
+
+// value は値 v を出力できる形式に変換する。
+func value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil, string, bool, int, int64, int32, uint, uint64, uint32, float64, float32:
+		return v
+	case time.Duration:
+		return v.String()
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprintf("%+v", v)
+}
+
+func writeJSON(buf *bytes.Buffer, fields []interface{}) {
+	buf.WriteByte('{')
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(fmt.Sprint(fields[i]))
+		buf.Write(k)
+		buf.WriteByte(':')
+		v, err := json.Marshal(value(fields[i+1]))
+		if err != nil {
+			v, _ = json.Marshal(fmt.Sprint(fields[i+1]))
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+}
+
+func writeLogfmt(buf *bytes.Buffer, fields []interface{}) {
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(fmt.Sprint(fields[i]))
+		buf.WriteByte('=')
+		v := value(fields[i+1])
+		if v == nil {
+			continue
+		}
+		s := fmt.Sprint(v)
+		if needsQuote(s) {
+			s = strconv.Quote(s)
+		}
+		buf.WriteString(s)
+	}
+}
+
+func needsQuote(s string) bool {
+	if s == "" {
+		return true
+	}
+	return strings.IndexFunc(s, func(r rune) bool {
+		return r <= ' ' || r == '=' || r == '"' || r == '\\' || !unicode.IsPrint(r)
+	}) >= 0
+}
@@ -0,0 +1,60 @@
+package logger_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/minodisk/resizer/logger"
+)
+
+func TestLogfmt(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	l := logger.New(&buf, logger.FormatLogfmt, logger.LevelInfo).With("request_id", "abc")
+	l.Debug("hidden")
+	l.Info("fetched", "url", "http://a.com/a b.png", "duration", 1500*time.Millisecond, "err", errors.New(`"x"`))
+
+	want := regexp.MustCompile(`^time=\S+ level=info msg=fetched request_id=abc url="http://a.com/a b.png" duration=1.5s err="\\"x\\""\n$`)
+	if !want.Match(buf.Bytes()) {
+		t.Errorf("got: %q", buf.String())
+	}
+}
+
+func TestJSON(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	l := logger.New(&buf, logger.FormatJSON, logger.LevelDebug)
+	ctx := logger.NewContext(context.Background(), l.With("request_id", "abc"))
+	logger.FromContext(ctx).Debug("resized", "width", 100, "odd")
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("%v: %s", err, buf.String())
+	}
+	for k, want := range map[string]interface{}{
+		"level":      "debug",
+		"msg":        "resized",
+		"request_id": "abc",
+		"width":      float64(100),
+		"odd":        nil,
+	} {
+		if v, ok := got[k]; !ok || v != want {
+			t.Errorf("%s got: %#v, want: %#v", k, v, want)
+		}
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	t.Parallel()
+
+	if logger.FromContext(context.Background()) != logger.Default() {
+		t.Errorf("should return the default logger")
+	}
+}
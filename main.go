@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/minodisk/resizer/logger"
 	"github.com/minodisk/resizer/options"
 	"github.com/minodisk/resizer/server"
 )
@@ -16,9 +17,26 @@ func main() {
 }
 
 func _main() error {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "sign":
+			return sign(os.Args[2:])
+		case "purge":
+			return purge(os.Args[2:])
+		}
+	}
+
 	o := &options.Options{}
 	if err := o.Parse(os.Args[1:]); err != nil {
 		return err
 	}
+	if o.PrintConfig {
+		return o.WriteConfig(os.Stdout)
+	}
+	level := logger.LevelInfo
+	if o.Verbose {
+		level = logger.LevelDebug
+	}
+	logger.SetDefault(logger.New(os.Stderr, o.LogFormat, level))
 	return server.Start(o)
 }
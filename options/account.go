@@ -3,6 +3,8 @@ package options
 import (
 	"encoding/json"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -36,6 +38,23 @@ func (a *ServiceAccount) Set(path string) error {
 	return nil
 }
 
+// Get は設定を出力する際の値として、秘密鍵を含まないファイルのパスを返す。
+func (a *ServiceAccount) Get() interface{} {
+	return a.Path
+}
+
+// setJSON はサービスアカウントの JSON を読み込み、一時ファイルに書き出す。
+func (a *ServiceAccount) setJSON(b []byte) error {
+	if err := json.Unmarshal(b, a); err != nil {
+		return errors.Wrap(err, "fail to unmarshal JSON")
+	}
+	a.Path = filepath.Join(os.TempDir(), "resizer-google-auth.json")
+	if err := ioutil.WriteFile(a.Path, b, 0644); err != nil {
+		return errors.Wrap(err, "fail to write the file of Google service account JSON")
+	}
+	return nil
+}
+
 func (a *ServiceAccount) UnmarshalJSON(data []byte) error {
 	type Alias ServiceAccount
 	var alias Alias
@@ -0,0 +1,181 @@
+package options
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var dsnPasswordRegexp = regexp.MustCompile(`^([^:@/]*):([^@]*)@`)
+
+// jsonValue はオブジェクトで値を指定できるオプションの値。
+type jsonValue interface {
+	setJSON([]byte) error
+}
+
+// loadConfig はパス path の設定ファイルを読み込み、fs のフラグに値を設定する。
+// 設定ファイルの形式は拡張子で判別する。
+// キーはフラグの名前で、set に含まれるフラグには値を設定しない。
+//
+//   host:
+//     - "*.example.com"
+//     - example.net/images/
+//   cache-size: 134217728
+//   fetch-timeout: 10s
+//   presets:
+//     thumb: {width: 320, height: 320, method: cover}
+func loadConfig(fs *flag.FlagSet, path string, set map[string]bool) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "fail to read the configuration file")
+	}
+	var m map[string]interface{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		// 大きな整数の精度を落とさないように、数値は文字列のまま読み込む
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.UseNumber()
+		err = d.Decode(&m)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &m)
+	case ".toml":
+		_, err = toml.Decode(string(b), &m)
+	default:
+		return errors.Errorf("unsupported format of the configuration file '%s'", ext)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "fail to parse the configuration file '%s'", path)
+	}
+
+	for key, value := range m {
+		name := strings.Replace(key, "_", "-", -1)
+		f := fs.Lookup(name)
+		if f == nil || name == FlagConfig || name == FlagPrintConfig {
+			return errors.Errorf("unknown option '%s' in the configuration file", key)
+		}
+		if set[name] {
+			continue
+		}
+		if err := setConfigValue(f, normalize(value)); err != nil {
+			return errors.Wrapf(err, "invalid value of '%s' in the configuration file", key)
+		}
+	}
+	return nil
+}
+
+// setConfigValue は設定ファイルの値 v をフラグ f に設定する。
+// 配列は要素ごとに設定し、オブジェクトは JSON として設定する。
+func setConfigValue(f *flag.Flag, v interface{}) error {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, e := range v {
+			if err := setConfigValue(f, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		jv, ok := f.Value.(jsonValue)
+		if !ok {
+			return errors.New("object isn't allowed")
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return jv.setJSON(b)
+	case string:
+		return f.Value.Set(v)
+	case json.Number:
+		return f.Value.Set(v.String())
+	case bool:
+		return f.Value.Set(strconv.FormatBool(v))
+	case int:
+		return f.Value.Set(strconv.Itoa(v))
+	case int64:
+		return f.Value.Set(strconv.FormatInt(v, 10))
+	case float64:
+		return f.Value.Set(strconv.FormatFloat(v, 'f', -1, 64))
+	default:
+		return errors.Errorf("unsupported value %v", v)
+	}
+}
+
+// normalize は YAML や TOML をデコードした値を JSON と同じ型に揃える。
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = normalize(e)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = normalize(e)
+		}
+		return m
+	case []map[string]interface{}:
+		s := make([]interface{}, len(v))
+		for i, e := range v {
+			s[i] = normalize(e)
+		}
+		return s
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, e := range v {
+			s[i] = normalize(e)
+		}
+		return s
+	}
+	return v
+}
+
+// WriteConfig は有効な設定を、設定ファイルとして読み込める JSON で w に書き出す。
+// 秘密の値は Redacted に置き換える。
+func (o *Options) WriteConfig(w io.Writer) error {
+	fs := o.flagSet()
+	m := map[string]interface{}{}
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == FlagConfig || f.Name == FlagPrintConfig {
+			return
+		}
+		g, ok := f.Value.(flag.Getter)
+		if !ok {
+			m[f.Name] = f.Value.String()
+			return
+		}
+		switch v := g.Get().(type) {
+		case time.Duration:
+			m[f.Name] = v.String()
+		default:
+			m[f.Name] = v
+		}
+	})
+	m[FlagDSN] = redactDSN(o.DataSourceName)
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
+// redactDSN はデータソース名 dsn のパスワードを伏せる。
+func redactDSN(dsn string) string {
+	return dsnPasswordRegexp.ReplaceAllString(dsn, "$1:"+Redacted+"@")
+}
@@ -0,0 +1,260 @@
+package options_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minodisk/resizer/options"
+)
+
+func TestParseConfig(t *testing.T) {
+	if j := os.Getenv(options.EnvGoogleAuthJSON); j != "" {
+		os.Unsetenv(options.EnvGoogleAuthJSON)
+		defer os.Setenv(options.EnvGoogleAuthJSON, j)
+	}
+	dir, err := ioutil.TempDir("", "resizer-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, c := range []struct {
+		name   string
+		file   string
+		config string
+		envs   map[string]string
+		args   []string
+		want   options.Options
+	}{
+		{
+			"yaml",
+			"config.yaml",
+			`
+host:
+  - a.com
+  - "*.b.com"
+cache-size: 1024
+fetch_timeout: 10s
+presets-only: true
+presets:
+  thumb: {width: 320, height: 320, method: cover}
+`,
+			map[string]string{},
+			[]string{},
+			options.Options{
+				AllowedHosts: options.MustHosts("a.com", "*.b.com"),
+				CacheSize:    1024,
+				FetchTimeout: 10 * time.Second,
+				MaxFetchSize: options.DefaultMaxFetchSize,
+				Port:         80,
+				PresetsOnly:  true,
+				Presets: options.Presets{
+					"thumb": options.Preset{Width: 320, Height: 320, Method: "cover"},
+				},
+			},
+		},
+		{
+			"toml",
+			"config.toml",
+			`
+host = ["a.com", "*.b.com"]
+cache-size = 1024
+fetch_timeout = "10s"
+
+[presets.thumb]
+width = 320
+height = 320
+method = "cover"
+`,
+			map[string]string{},
+			[]string{},
+			options.Options{
+				AllowedHosts: options.MustHosts("a.com", "*.b.com"),
+				CacheSize:    1024,
+				FetchTimeout: 10 * time.Second,
+				MaxFetchSize: options.DefaultMaxFetchSize,
+				Port:         80,
+				Presets: options.Presets{
+					"thumb": options.Preset{Width: 320, Height: 320, Method: "cover"},
+				},
+			},
+		},
+		{
+			"json",
+			"config.json",
+			`{"host": "a.com,*.b.com", "cache-size": 1024, "fetch-timeout": "10s", "max-fetch-size": 9007199254740993}`,
+			map[string]string{},
+			[]string{},
+			options.Options{
+				AllowedHosts: options.MustHosts("a.com", "*.b.com"),
+				CacheSize:    1024,
+				FetchTimeout: 10 * time.Second,
+				MaxFetchSize: 9007199254740993,
+				Port:         80,
+			},
+		},
+		{
+			"overridden by env and args",
+			"override.yaml",
+			`
+bucket: foo
+host: [a.com, b.com]
+port: 8080
+`,
+			map[string]string{
+				options.EnvBucket: "bar",
+			},
+			[]string{
+				"-host", "c.com",
+			},
+			options.Options{
+				Bucket:       "bar",
+				AllowedHosts: options.MustHosts("c.com"),
+				CacheSize:    options.DefaultCacheSize,
+				FetchTimeout: options.DefaultFetchTimeout,
+				MaxFetchSize: options.DefaultMaxFetchSize,
+				Port:         8080,
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(dir, c.file)
+			if err := ioutil.WriteFile(path, []byte(c.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			for _, k := range options.Envs {
+				os.Setenv(k, "")
+			}
+			for k, v := range c.envs {
+				os.Setenv(k, v)
+			}
+			os.Setenv(options.EnvConfig, path)
+			defer os.Setenv(options.EnvConfig, "")
+
+			o := &options.Options{}
+			if err := o.Parse(c.args); err != nil {
+				t.Fatal(err)
+			}
+			got := options.Options{
+				ConfigFile:   o.ConfigFile,
+				AllowedHosts: o.AllowedHosts,
+				Bucket:       o.Bucket,
+				CacheSize:    o.CacheSize,
+				FetchTimeout: o.FetchTimeout,
+				MaxFetchSize: o.MaxFetchSize,
+				Port:         o.Port,
+				Presets:      o.Presets,
+				PresetsOnly:  o.PresetsOnly,
+			}
+			c.want.ConfigFile = path
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("\ngot:\n%+v\nwant:\n%+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestParseConfigError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resizer-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, c := range []struct {
+		name   string
+		file   string
+		config string
+		want   string
+	}{
+		{
+			"unknown option",
+			"unknown.yaml",
+			"foo: bar",
+			"unknown option 'foo'",
+		},
+		{
+			"invalid value",
+			"invalid.yaml",
+			"fetch-timeout: 10",
+			"invalid value of 'fetch-timeout'",
+		},
+		{
+			"unsupported format",
+			"config.ini",
+			"bucket=foo",
+			"unsupported format",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(dir, c.file)
+			if err := ioutil.WriteFile(path, []byte(c.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			for _, k := range options.Envs {
+				os.Setenv(k, "")
+			}
+			o := &options.Options{}
+			err := o.Parse([]string{"-config", path})
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Errorf("error should contain %q, but got %v", c.want, err)
+			}
+		})
+	}
+}
+
+func TestWriteConfig(t *testing.T) {
+	for _, k := range options.Envs {
+		os.Setenv(k, "")
+	}
+	o := &options.Options{}
+	if err := o.Parse([]string{
+		"-dsn", "user:p4ssw0rd@tcp(localhost:3306)/resizer",
+		"-host", "a.com",
+		"-signing-key", "k3y1,k3y2",
+		"-s3-secret-access-key", "s3cr3t",
+		"-admin-token", "t0k3n",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	before := *o
+	var buf bytes.Buffer
+	if err := o.WriteConfig(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*o, before) {
+		t.Errorf("options shouldn't be changed by WriteConfig\n got: %+v\nwant: %+v", *o, before)
+	}
+	if strings.Contains(buf.String(), "p4ssw0rd") || strings.Contains(buf.String(), "k3y1") ||
+		strings.Contains(buf.String(), "s3cr3t") || strings.Contains(buf.String(), "t0k3n") {
+		t.Errorf("secrets should be redacted:\n%s", buf.String())
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]interface{}{
+		options.FlagDSN:               "user:" + options.Redacted + "@tcp(localhost:3306)/resizer",
+		options.FlagHost:              []interface{}{"a.com"},
+		options.FlagSigningKey:        []interface{}{options.Redacted, options.Redacted},
+		options.FlagFetchTimeout:      "30s",
+		options.FlagPort:              float64(80),
+		options.FlagS3SecretAccessKey: options.Redacted,
+		options.FlagAdminToken:        options.Redacted,
+	} {
+		if !reflect.DeepEqual(got[key], want) {
+			t.Errorf("%s\n got: %#v\nwant: %#v", key, got[key], want)
+		}
+	}
+	if _, ok := got[options.FlagConfig]; ok {
+		t.Errorf("%s shouldn't be written", options.FlagConfig)
+	}
+}
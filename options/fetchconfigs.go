@@ -0,0 +1,149 @@
+package options
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FetchConfig は元画像を取得する際のリクエストの設定。
+// ゼロ値のフィールドは指定されていないものとして扱う。
+type FetchConfig struct {
+	Headers     map[string]string `json:"headers,omitempty"`
+	Username    string            `json:"username,omitempty"`
+	Password    string            `json:"password,omitempty"`
+	BearerToken string            `json:"bearer_token,omitempty"`
+	UserAgent   string            `json:"user_agent,omitempty"`
+	// TLSCert と TLSKey はクライアント証明書と秘密鍵の PEM ファイルのパス。
+	TLSCert string `json:"tls_cert,omitempty"`
+	TLSKey  string `json:"tls_key,omitempty"`
+
+	// host は FetchConfigs のキーを解析したパターン。
+	host HostPattern
+}
+
+// Redact はパスワード、トークンとヘッダーの値を伏せた設定を返す。
+func (c FetchConfig) Redact() FetchConfig {
+	if c.Password != "" {
+		c.Password = Redacted
+	}
+	if c.BearerToken != "" {
+		c.BearerToken = Redacted
+	}
+	if len(c.Headers) > 0 {
+		hs := make(map[string]string, len(c.Headers))
+		for k := range c.Headers {
+			hs[k] = Redacted
+		}
+		c.Headers = hs
+	}
+	return c
+}
+
+func (c FetchConfig) validate() error {
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return errors.New("both of tls_cert and tls_key should be specified")
+	}
+	return nil
+}
+
+// FetchConfigs はホストのパターンをキーにした、元画像を取得する際のリクエストの設定の一覧。
+// パターンは Hosts と同じ形式で指定する。
+// パターンは追加する際に解析するため、FetchConfigs は Set または NewFetchConfigs で作成する。
+type FetchConfigs map[string]FetchConfig
+
+// NewFetchConfigs はパターンをキーにした設定の一覧 m を検証し、パターンを解析した FetchConfigs を返す。
+func NewFetchConfigs(m map[string]FetchConfig) (FetchConfigs, error) {
+	cs := make(FetchConfigs, len(m))
+	for p, c := range m {
+		host, err := ParseHostPattern(p)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid fetch config for '%s'", p)
+		}
+		c.host = host
+		cs[p] = c
+	}
+	return cs, nil
+}
+
+func (cs *FetchConfigs) String() string {
+	patterns := make([]string, 0, len(*cs))
+	for p := range *cs {
+		patterns = append(patterns, p)
+	}
+	sort.Strings(patterns)
+	return strings.Join(patterns, ", ")
+}
+
+// Get は秘密の値を伏せた設定の一覧を返す。
+func (cs *FetchConfigs) Get() interface{} {
+	m := make(map[string]FetchConfig, len(*cs))
+	for p, c := range *cs {
+		m[p] = c.Redact()
+	}
+	return m
+}
+
+// Set はパス path の JSON ファイルから設定を読み込む。
+//
+//   {
+//     "*.example.com": {"bearer_token": "...", "headers": {"X-Api-Key": "..."}},
+//     "private.example.net": {"username": "user", "password": "pass", "tls_cert": "cert.pem", "tls_key": "key.pem"}
+//   }
+func (cs *FetchConfigs) Set(path string) error {
+	if path == "" {
+		return errors.New("path to fetch configs JSON isn't specified")
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "fail to read the file of fetch configs JSON")
+	}
+	if err := cs.setJSON(b); err != nil {
+		return errors.Wrap(err, "fail to unmarshal JSON")
+	}
+	return nil
+}
+
+func (cs *FetchConfigs) setJSON(b []byte) error {
+	m := map[string]FetchConfig{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	parsed, err := NewFetchConfigs(m)
+	if err != nil {
+		return err
+	}
+	if *cs == nil {
+		*cs = FetchConfigs{}
+	}
+	for p, c := range parsed {
+		(*cs)[p] = c
+	}
+	return nil
+}
+
+// Lookup は URL u に一致するパターンの設定を返す。
+// 複数のパターンが一致する場合は、最も長いパターンの設定を返す。
+func (cs FetchConfigs) Lookup(u *url.URL) (string, FetchConfig, bool) {
+	var (
+		pattern string
+		found   FetchConfig
+		ok      bool
+	)
+	for p, c := range cs {
+		if !c.host.Match(u) {
+			continue
+		}
+		if !ok || len(p) > len(pattern) || (len(p) == len(pattern) && p < pattern) {
+			pattern, found, ok = p, c, true
+		}
+	}
+	return pattern, found, ok
+}
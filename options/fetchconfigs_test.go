@@ -0,0 +1,97 @@
+package options_test
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/minodisk/resizer/options"
+)
+
+func TestFetchConfigsSet(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		json string
+		want map[string]options.FetchConfig
+		ok   bool
+	}{
+		{
+			"valid",
+			`{"*.a.com": {"bearer_token": "token"}, "b.com/images/": {"username": "user", "password": "pass"}}`,
+			map[string]options.FetchConfig{
+				"*.a.com":       options.FetchConfig{BearerToken: "token"},
+				"b.com/images/": options.FetchConfig{Username: "user", Password: "pass"},
+			},
+			true,
+		},
+		{
+			"invalid pattern",
+			`{"a..com": {"bearer_token": "token"}}`,
+			nil,
+			false,
+		},
+		{
+			"certificate without key",
+			`{"a.com": {"tls_cert": "cert.pem"}}`,
+			nil,
+			false,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			f, err := ioutil.TempFile("", "resizer-fetch-configs")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(f.Name())
+			f.WriteString(c.json)
+			f.Close()
+
+			var cs options.FetchConfigs
+			err = cs.Set(f.Name())
+			if (err == nil) != c.ok {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !c.ok {
+				return
+			}
+			want, err := options.NewFetchConfigs(c.want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(cs, want) {
+				t.Errorf("\ngot:\n%+v\nwant:\n%+v", cs, want)
+			}
+		})
+	}
+}
+
+func TestFetchConfigsLookup(t *testing.T) {
+	cs, err := options.NewFetchConfigs(map[string]options.FetchConfig{
+		".a.com":        options.FetchConfig{UserAgent: "suffix"},
+		"img.a.com":     options.FetchConfig{UserAgent: "exact"},
+		"b.com/images/": options.FetchConfig{UserAgent: "path"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		url  string
+		want string
+	}{
+		{"https://a.com/1.jpg", "suffix"},
+		{"https://img.a.com/1.jpg", "exact"},
+		{"https://b.com/images/1.jpg", "path"},
+		{"https://b.com/1.jpg", ""},
+	} {
+		u, err := url.Parse(c.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, got, _ := cs.Lookup(u)
+		if got.UserAgent != c.want {
+			t.Errorf("%s: got %q, want %q", c.url, got.UserAgent, c.want)
+		}
+	}
+}
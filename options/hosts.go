@@ -1,28 +1,257 @@
 package options
 
-import "strings"
+import (
+	"net"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
 
-type Hosts []string
+	"github.com/pkg/errors"
+)
+
+// Hosts は元画像の取得を許可するホストのパターンの一覧。
+//
+// パターンは以下のいずれかの形式で指定する。
+//
+//   example.com          ホストが一致する
+//   *.example.com        サブドメインが一致する（example.com 自体は含まない）
+//   .example.com         example.com 自体とサブドメインが一致する
+//   ~^img\d+\.example\.com$  ポートを含むホストが正規表現に一致する
+//
+// 正規表現以外のパターンには、ポートとパスのプレフィックスを付加できる。
+// ポートを指定しない場合は、ポートの指定がないかデフォルトのポート（80, 443）のみ一致する。
+//
+//   example.com:8080     ポート 8080 のみ一致する
+//   example.com:*        任意のポートが一致する
+//   example.com/images/  パスが /images/ で始まる場合のみ一致する
+//
+// パターンは追加する際に解析するため、Hosts は Set または NewHosts で作成する。
+type Hosts []HostPattern
+
+const (
+	hostExact = iota
+	hostWildcard
+	hostSuffix
+	hostRegexp
+)
+
+// HostPattern は解析済みのホストのパターン。
+type HostPattern struct {
+	pattern string
+	kind    int
+	name    string
+	port    string
+	path    string
+	re      *regexp.Regexp
+}
+
+var (
+	hostNameRegexp = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+	portRegexp     = regexp.MustCompile(`^(\*|[0-9]{1,5})$`)
+)
+
+// NewHosts はパターンの一覧 patterns を解析した Hosts を返す。
+func NewHosts(patterns ...string) (Hosts, error) {
+	hs := make(Hosts, 0, len(patterns))
+	for _, s := range patterns {
+		p, err := ParseHostPattern(s)
+		if err != nil {
+			return nil, err
+		}
+		hs = append(hs, p)
+	}
+	return hs, nil
+}
+
+// MustHosts は NewHosts と同様に Hosts を返す。
+// パターンが不正な場合は panic する。
+func MustHosts(patterns ...string) Hosts {
+	hs, err := NewHosts(patterns...)
+	if err != nil {
+		panic(err)
+	}
+	return hs
+}
 
 func (hs *Hosts) String() string {
-	return strings.Join(*hs, ", ")
+	return strings.Join(hs.Patterns(), ", ")
+}
+
+func (hs *Hosts) Get() interface{} {
+	return hs.Patterns()
 }
 
+// Patterns は解析する前のパターンの一覧を返す。
+func (hs Hosts) Patterns() []string {
+	patterns := make([]string, len(hs))
+	for i, p := range hs {
+		patterns[i] = p.pattern
+	}
+	return patterns
+}
+
+// Set はカンマで区切られたパターンを追加する。
+// 正規表現の {2,3} や [a,b] のように、括弧の中のカンマでは区切らない。
 func (hs *Hosts) Set(host string) error {
-	for _, h := range strings.Split(host, ",") {
-		*hs = append(*hs, strings.TrimSpace(h))
+	for _, h := range splitHostPatterns(host) {
+		p, err := ParseHostPattern(strings.TrimSpace(h))
+		if err != nil {
+			return err
+		}
+		*hs = append(*hs, p)
 	}
 	return nil
 }
 
+// splitHostPatterns は s を括弧の外にあるカンマで区切る。
+// ホストにカンマは含まれないため、括弧の外のカンマは常にパターンの区切りとみなす。
+func splitHostPatterns(s string) []string {
+	var (
+		patterns []string
+		start    int
+		depth    int
+		class    bool
+	)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case class:
+			class = c != ']'
+		case c == '[':
+			class = true
+		case c == '{' || c == '(':
+			depth++
+		case (c == '}' || c == ')') && depth > 0:
+			depth--
+		case c == ',' && depth == 0:
+			patterns = append(patterns, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(patterns, s[start:])
+}
+
+// Contains は host が許可されたホストかを判定する。
+// パスのプレフィックスが指定されたパターンには一致しない。
+// 許可するホストが指定されていない場合は全てのホストを許可する。
 func (hs Hosts) Contains(host string) bool {
+	return hs.Match(&url.URL{Host: host})
+}
+
+// Match は URL u のホストとパスが許可されているかを判定する。
+// 許可するホストが指定されていない場合は全ての URL を許可する。
+func (hs Hosts) Match(u *url.URL) bool {
 	if len(hs) == 0 {
 		return true
 	}
-	for _, h := range hs {
-		if h == host {
+	for _, p := range hs {
+		if p.Match(u) {
 			return true
 		}
 	}
 	return false
 }
+
+// ParseHostPattern はパターン s を解析する。
+func ParseHostPattern(s string) (HostPattern, error) {
+	p := HostPattern{pattern: s}
+	if s == "" {
+		return p, errors.New("host pattern shouldn't be empty")
+	}
+	if strings.HasPrefix(s, "~") {
+		re, err := regexp.Compile(s[1:])
+		if err != nil {
+			return p, errors.Wrapf(err, "invalid host pattern '%s'", s)
+		}
+		p.kind = hostRegexp
+		p.re = re
+		return p, nil
+	}
+
+	h := strings.ToLower(s)
+	if i := strings.Index(h, "/"); i != -1 {
+		p.path = h[i:]
+		h = h[:i]
+	}
+	if strings.HasSuffix(h, ":*") {
+		p.port = "*"
+		h = strings.TrimSuffix(h, ":*")
+	} else if host, port, err := net.SplitHostPort(h); err == nil {
+		p.port = port
+		h = host
+	}
+	if p.port != "" && !portRegexp.MatchString(p.port) {
+		return p, errors.Errorf("invalid port in host pattern '%s'", s)
+	}
+
+	switch {
+	case strings.HasPrefix(h, "*."):
+		p.kind = hostWildcard
+		p.name = h[1:]
+	case strings.HasPrefix(h, "."):
+		p.kind = hostSuffix
+		p.name = h
+	default:
+		p.kind = hostExact
+		p.name = strings.Trim(h, "[]")
+	}
+	name := strings.TrimPrefix(p.name, ".")
+	if net.ParseIP(name) == nil && !hostNameRegexp.MatchString(name) {
+		return p, errors.Errorf("invalid host pattern '%s'", s)
+	}
+	return p, nil
+}
+
+// String は解析する前のパターンを返す。
+func (p HostPattern) String() string {
+	return p.pattern
+}
+
+// Match は URL u のホストとパスがパターンに一致するかを判定する。
+func (p HostPattern) Match(u *url.URL) bool {
+	if p.kind == hostRegexp {
+		return p.re.MatchString(u.Host)
+	}
+
+	name := strings.ToLower(u.Hostname())
+	port := u.Port()
+	switch p.kind {
+	case hostExact:
+		if name != p.name {
+			return false
+		}
+	case hostWildcard:
+		if !strings.HasSuffix(name, p.name) {
+			return false
+		}
+	case hostSuffix:
+		if name != p.name[1:] && !strings.HasSuffix(name, p.name) {
+			return false
+		}
+	}
+
+	switch p.port {
+	case "*":
+	case "":
+		if port != "" && port != "80" && port != "443" {
+			return false
+		}
+	default:
+		if port != p.port {
+			return false
+		}
+	}
+
+	if p.path != "" {
+		cleaned := path.Clean("/" + u.Path)
+		if strings.HasSuffix(u.Path, "/") && cleaned != "/" {
+			cleaned += "/"
+		}
+		if !strings.HasPrefix(cleaned, p.path) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+package options_test
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/minodisk/resizer/options"
+)
+
+func TestHostsSet(t *testing.T) {
+	t.Parallel()
+	for _, c := range []struct {
+		name  string
+		value string
+		err   bool
+	}{
+		{"host", "example.com", false},
+		{"host with port", "example.com:8080", false},
+		{"host with any port", "example.com:*", false},
+		{"wildcard", "*.example.com", false},
+		{"suffix", ".example.com", false},
+		{"path prefix", "example.com/images/", false},
+		{"IPv6", "[::1]:8080", false},
+		{"regexp", `~^img\d+\.example\.com$`, false},
+		{"empty", "", true},
+		{"invalid wildcard", "img*.example.com", true},
+		{"invalid port", "example.com:http", true},
+		{"invalid regexp", "~^img(", true},
+	} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			var hs options.Hosts
+			if err := hs.Set(c.value); (err != nil) != c.err {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestHostsSetMultiple(t *testing.T) {
+	t.Parallel()
+	for _, c := range []struct {
+		name  string
+		value string
+		want  []string
+	}{
+		{"hosts", "example.com, *.example.net", []string{"example.com", "*.example.net"}},
+		{"regexp with repetition", `example.com,~^img\d{2,3}\.example\.com$`, []string{"example.com", `~^img\d{2,3}\.example\.com$`}},
+		{"regexp with class", `~^img[a,b]\.example\.com$,example.com`, []string{`~^img[a,b]\.example\.com$`, "example.com"}},
+		{"regexp with escaped brace", `~^img\{,example.com`, []string{`~^img\{`, "example.com"}},
+	} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			var hs options.Hosts
+			if err := hs.Set(c.value); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(hs.Patterns(), c.want) {
+				t.Errorf("hosts\n got: %#v\nwant: %#v", hs.Patterns(), c.want)
+			}
+		})
+	}
+}
+
+func TestHostsMatch(t *testing.T) {
+	t.Parallel()
+	for _, c := range []struct {
+		name  string
+		hosts options.Hosts
+		url   string
+		want  bool
+	}{
+		{"no hosts", options.Hosts(nil), "http://example.com/a.jpg", true},
+		{"exact", options.MustHosts("example.com"), "http://example.com/a.jpg", true},
+		{"exact with case", options.MustHosts("example.com"), "http://EXAMPLE.com/a.jpg", true},
+		{"exact with other host", options.MustHosts("example.com"), "http://foo.example.com/a.jpg", false},
+		{"default port", options.MustHosts("example.com"), "https://example.com:443/a.jpg", true},
+		{"other port", options.MustHosts("example.com"), "http://example.com:8080/a.jpg", false},
+		{"specified port", options.MustHosts("example.com:8080"), "http://example.com:8080/a.jpg", true},
+		{"any port", options.MustHosts("example.com:*"), "http://example.com:8080/a.jpg", true},
+		{"wildcard", options.MustHosts("*.example.com"), "http://img1.example.com/a.jpg", true},
+		{"wildcard with deep subdomain", options.MustHosts("*.example.com"), "http://a.b.example.com/a.jpg", true},
+		{"wildcard with apex", options.MustHosts("*.example.com"), "http://example.com/a.jpg", false},
+		{"wildcard with similar host", options.MustHosts("*.example.com"), "http://fooexample.com/a.jpg", false},
+		{"suffix with apex", options.MustHosts(".example.com"), "http://example.com/a.jpg", true},
+		{"suffix with subdomain", options.MustHosts(".example.com"), "http://img.example.com/a.jpg", true},
+		{"regexp", options.MustHosts(`~^img\d+\.example\.com$`), "http://img12.example.com/a.jpg", true},
+		{"regexp not matched", options.MustHosts(`~^img\d+\.example\.com$`), "http://imgx.example.com/a.jpg", false},
+		{"path prefix", options.MustHosts("example.com/images/"), "http://example.com/images/a.jpg", true},
+		{"other path", options.MustHosts("example.com/images/"), "http://example.com/secret/a.jpg", false},
+		{"path traversal", options.MustHosts("example.com/images/"), "http://example.com/images/../secret/a.jpg", false},
+	} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			u, err := url.Parse(c.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c.hosts.Match(u); got != c.want {
+				t.Errorf("got: %t, want: %t", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package options
+
+import "strings"
+
+// Keys は署名に使う秘密鍵の一覧。
+// 鍵を入れ替える際は新しい鍵と古い鍵を併せて指定する。
+type Keys []string
+
+// String は鍵を出力しないように伏せた値を返す。
+func (ks *Keys) String() string {
+	s := make([]string, len(*ks))
+	for i := range *ks {
+		s[i] = Redacted
+	}
+	return strings.Join(s, ", ")
+}
+
+// Get は鍵を出力しないように伏せた値の一覧を返す。
+func (ks *Keys) Get() interface{} {
+	s := make([]string, len(*ks))
+	for i := range *ks {
+		s[i] = Redacted
+	}
+	return s
+}
+
+func (ks *Keys) Set(key string) error {
+	for _, k := range strings.Split(key, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			*ks = append(*ks, k)
+		}
+	}
+	return nil
+}
+
+// Bytes は鍵をバイト列の一覧として返す。
+func (ks Keys) Bytes() [][]byte {
+	b := make([][]byte, len(ks))
+	for i, k := range ks {
+		b[i] = []byte(k)
+	}
+	return b
+}
@@ -0,0 +1,62 @@
+package options
+
+import (
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Networks は CIDR 表記または IP アドレスで指定されたネットワークの一覧。
+type Networks []*net.IPNet
+
+func (ns *Networks) String() string {
+	s := make([]string, len(*ns))
+	for i, n := range *ns {
+		s[i] = n.String()
+	}
+	return strings.Join(s, ", ")
+}
+
+func (ns *Networks) Get() interface{} {
+	s := make([]string, len(*ns))
+	for i, n := range *ns {
+		s[i] = n.String()
+	}
+	return s
+}
+
+func (ns *Networks) Set(network string) error {
+	for _, n := range strings.Split(network, ",") {
+		n = strings.TrimSpace(n)
+		if n == "" {
+			continue
+		}
+		if !strings.Contains(n, "/") {
+			ip := net.ParseIP(n)
+			if ip == nil {
+				return errors.Errorf("invalid IP address '%s'", n)
+			}
+			if ip.To4() != nil {
+				n += "/32"
+			} else {
+				n += "/128"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(n)
+		if err != nil {
+			return errors.Wrapf(err, "invalid network '%s'", n)
+		}
+		*ns = append(*ns, ipnet)
+	}
+	return nil
+}
+
+func (ns Networks) Contains(ip net.IP) bool {
+	for _, n := range ns {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
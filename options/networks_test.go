@@ -0,0 +1,55 @@
+package options_test
+
+import (
+	"testing"
+
+	"github.com/minodisk/resizer/options"
+)
+
+func TestNetworksSet(t *testing.T) {
+	t.Parallel()
+	for _, c := range []struct {
+		name  string
+		value string
+		want  string
+		err   bool
+	}{
+		{
+			"CIDR",
+			"10.0.0.0/8",
+			"10.0.0.0/8",
+			false,
+		},
+		{
+			"IP addresses",
+			"192.168.0.1, ::1",
+			"192.168.0.1/32, ::1/128",
+			false,
+		},
+		{
+			"invalid IP address",
+			"foo",
+			"",
+			true,
+		},
+		{
+			"invalid CIDR",
+			"10.0.0.0/33",
+			"",
+			true,
+		},
+	} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			var ns options.Networks
+			err := ns.Set(c.value)
+			if (err != nil) != c.err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := ns.String(); !c.err && got != c.want {
+				t.Errorf("got: %s, want: %s", got, c.want)
+			}
+		})
+	}
+}
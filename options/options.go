@@ -1,113 +1,566 @@
 package options
 
 import (
-	"encoding/json"
 	"flag"
-	"io/ioutil"
+	"fmt"
 	"os"
-	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
 const (
 	EnvGoogleAuthJSON = "GOOGLE_AUTH_JSON"
 
+	EnvAWSAccessKeyID               = "AWS_ACCESS_KEY_ID"
+	EnvAWSRegion                    = "AWS_REGION"
+	EnvAWSSecretAccessKey           = "AWS_SECRET_ACCESS_KEY"
 	EnvGoogleApplicationCredentials = "GOOGLE_APPLICATION_CREDENTIALS"
+	EnvOTLPEndpoint                 = "OTEL_EXPORTER_OTLP_ENDPOINT"
 	EnvAccount                      = "RESIZER_ACCOUNT"
+	EnvAdminToken                   = "RESIZER_ADMIN_TOKEN"
+	EnvAllowNetwork                 = "RESIZER_ALLOW_NETWORK"
 	EnvBucket                       = "RESIZER_BUCKET"
+	EnvCacheEntries                 = "RESIZER_CACHE_ENTRIES"
+	EnvCacheObjectSize              = "RESIZER_CACHE_OBJECT_SIZE"
+	EnvCacheSize                    = "RESIZER_CACHE_SIZE"
+	EnvConfig                       = "RESIZER_CONFIG"
 	EnvConnections                  = "RESIZER_CONNECTIONS"
 	EnvDSN                          = "RESIZER_DSN"
+	EnvFetchConfig                  = "RESIZER_FETCH_CONFIG"
+	EnvFetchConnectTimeout          = "RESIZER_FETCH_CONNECT_TIMEOUT"
+	EnvFetchTimeout                 = "RESIZER_FETCH_TIMEOUT"
 	EnvHost                         = "RESIZER_HOST"
+	EnvLogFormat                    = "RESIZER_LOG_FORMAT"
+	EnvMaxAge                       = "RESIZER_MAX_AGE"
+	EnvMaxFetchSize                 = "RESIZER_MAX_FETCH_SIZE"
+	EnvMaxHeight                    = "RESIZER_MAX_HEIGHT"
+	EnvMaxPixels                    = "RESIZER_MAX_PIXELS"
+	EnvMaxRedirects                 = "RESIZER_MAX_REDIRECTS"
+	EnvMaxWidth                     = "RESIZER_MAX_WIDTH"
+	EnvMetricsPort                  = "RESIZER_METRICS_PORT"
+	EnvNaming                       = "RESIZER_NAMING"
+	EnvOrigin                       = "RESIZER_ORIGIN"
 	EnvPort                         = "RESIZER_PORT"
 	EnvPrefix                       = "RESIZER_PREFIX"
+	EnvPresets                      = "RESIZER_PRESETS"
+	EnvPresetsOnly                  = "RESIZER_PRESETS_ONLY"
+	EnvQueueSize                    = "RESIZER_QUEUE_SIZE"
+	EnvQueueTimeout                 = "RESIZER_QUEUE_TIMEOUT"
+	EnvS3AccessKeyID                = "RESIZER_S3_ACCESS_KEY_ID"
+	EnvS3Endpoint                   = "RESIZER_S3_ENDPOINT"
+	EnvS3Region                     = "RESIZER_S3_REGION"
+	EnvS3SecretAccessKey            = "RESIZER_S3_SECRET_ACCESS_KEY"
+	EnvSaveQueueDir                 = "RESIZER_SAVE_QUEUE_DIR"
+	EnvSaveRetries                  = "RESIZER_SAVE_RETRIES"
+	EnvSaveRetryInterval            = "RESIZER_SAVE_RETRY_INTERVAL"
+	EnvShard                        = "RESIZER_SHARD"
+	EnvShutdownTimeout              = "RESIZER_SHUTDOWN_TIMEOUT"
+	EnvSigningKey                   = "RESIZER_SIGNING_KEY"
+	EnvSourceCacheDir               = "RESIZER_SOURCE_CACHE_DIR"
+	EnvSourceCacheMaxAge            = "RESIZER_SOURCE_CACHE_MAX_AGE"
+	EnvSourceCacheSize              = "RESIZER_SOURCE_CACHE_SIZE"
+	EnvSourceCacheTTL               = "RESIZER_SOURCE_CACHE_TTL"
+	EnvSourceRoot                   = "RESIZER_SOURCE_ROOT"
+	EnvTraceEndpoint                = "RESIZER_TRACE_ENDPOINT"
+	EnvTraceExporter                = "RESIZER_TRACE_EXPORTER"
 	EnvVerbose                      = "RESIZER_VERBOSE"
+	EnvWorkers                      = "RESIZER_WORKERS"
+	EnvWriteThrough                 = "RESIZER_WRITE_THROUGH"
+	EnvWriteThroughTimeout          = "RESIZER_WRITE_THROUGH_TIMEOUT"
 
-	FlagAccount     = "account"
-	FlagBucket      = "bucket"
-	FlagConnections = "connections"
-	FlagDSN         = "dsn"
-	FlagHost        = "host"
-	FlagPort        = "port"
-	FlagPrefix      = "prefix"
-	FlagVerbose     = "verbose"
+	FlagAccount             = "account"
+	FlagAdminToken          = "admin-token"
+	FlagAllowNetwork        = "allow-network"
+	FlagBucket              = "bucket"
+	FlagCacheEntries        = "cache-entries"
+	FlagCacheObjectSize     = "cache-object-size"
+	FlagCacheSize           = "cache-size"
+	FlagConfig              = "config"
+	FlagConnections         = "connections"
+	FlagDSN                 = "dsn"
+	FlagFetchConfig         = "fetch-config"
+	FlagFetchConnectTimeout = "fetch-connect-timeout"
+	FlagFetchTimeout        = "fetch-timeout"
+	FlagHost                = "host"
+	FlagLogFormat           = "log-format"
+	FlagMaxAge              = "max-age"
+	FlagMaxFetchSize        = "max-fetch-size"
+	FlagMaxHeight           = "max-height"
+	FlagMaxPixels           = "max-pixels"
+	FlagMaxRedirects        = "max-redirects"
+	FlagMaxWidth            = "max-width"
+	FlagMetricsPort         = "metrics-port"
+	FlagNaming              = "naming"
+	FlagOrigin              = "origin"
+	FlagPort                = "port"
+	FlagPrefix              = "prefix"
+	FlagPresets             = "presets"
+	FlagPresetsOnly         = "presets-only"
+	FlagPrintConfig         = "print-config"
+	FlagQueueSize           = "queue-size"
+	FlagQueueTimeout        = "queue-timeout"
+	FlagS3AccessKeyID       = "s3-access-key-id"
+	FlagS3Endpoint          = "s3-endpoint"
+	FlagS3Region            = "s3-region"
+	FlagS3SecretAccessKey   = "s3-secret-access-key"
+	FlagSaveQueueDir        = "save-queue-dir"
+	FlagSaveRetries         = "save-retries"
+	FlagSaveRetryInterval   = "save-retry-interval"
+	FlagShard               = "shard"
+	FlagShutdownTimeout     = "shutdown-timeout"
+	FlagSigningKey          = "signing-key"
+	FlagSourceCacheDir      = "source-cache-dir"
+	FlagSourceCacheMaxAge   = "source-cache-max-age"
+	FlagSourceCacheSize     = "source-cache-size"
+	FlagSourceCacheTTL      = "source-cache-ttl"
+	FlagSourceRoot          = "source-root"
+	FlagTraceEndpoint       = "trace-endpoint"
+	FlagTraceExporter       = "trace-exporter"
+	FlagVerbose             = "verbose"
+	FlagWorkers             = "workers"
+	FlagWriteThrough        = "write-through"
+	FlagWriteThroughTimeout = "write-through-timeout"
+)
+
+const (
+	DefaultCacheMaxAge  = 6 * 30 * 24 * time.Hour
+	DefaultCacheEntries = 10000
+	DefaultCacheSize    = 64 << 20
+
+	DefaultSourceCacheTTL    = time.Hour
+	DefaultSourceCacheMaxAge = 24 * time.Hour
+
+	DefaultFetchConnectTimeout = 5 * time.Second
+	DefaultFetchTimeout        = 30 * time.Second
+	DefaultMaxFetchSize        = 32 << 20
+	DefaultMaxRedirects        = 5
+
+	DefaultMaxPixels = 50 * 1000 * 1000
+
+	DefaultQueueSize    = 256
+	DefaultQueueTimeout = 10 * time.Second
+
+	DefaultShutdownTimeout = 30 * time.Second
+
+	DefaultSaveRetries       = 10
+	DefaultSaveRetryInterval = time.Second
+
+	DefaultWriteThroughTimeout = 10 * time.Second
+
+	NamingRandom  = "random"
+	NamingHash    = "hash"
+	NamingDefault = NamingRandom
+
+	LogFormatJSON    = "json"
+	LogFormatLogfmt  = "logfmt"
+	LogFormatDefault = LogFormatLogfmt
+
+	TraceExporterNone   = ""
+	TraceExporterStdout = "stdout"
+	TraceExporterOTLP   = "otlp"
+
+	DefaultTraceEndpoint = "http://localhost:4318"
+
+	// Redacted は秘密の値を出力する際に置き換える文字列。
+	Redacted = "[REDACTED]"
 )
 
 var (
-	Envs = []string{
-		EnvGoogleApplicationCredentials,
-		EnvAccount,
-		EnvBucket,
-		EnvConnections,
-		EnvDSN,
-		EnvHost,
-		EnvPort,
-		EnvPrefix,
-		EnvVerbose,
-	}
-	Flags = []string{
-		FlagAccount,
-		FlagAccount,
-		FlagBucket,
-		FlagConnections,
-		FlagDSN,
-		FlagHost,
-		FlagPort,
-		FlagPrefix,
-		FlagVerbose,
+	// EnvFlagMap は環境変数と、その値を設定するフラグの対応。
+	EnvFlagMap = map[string]string{
+		EnvAWSAccessKeyID:               FlagS3AccessKeyID,
+		EnvAWSRegion:                    FlagS3Region,
+		EnvAWSSecretAccessKey:           FlagS3SecretAccessKey,
+		EnvOTLPEndpoint:                 FlagTraceEndpoint,
+		EnvGoogleApplicationCredentials: FlagAccount,
+		EnvAccount:                      FlagAccount,
+		EnvAdminToken:                   FlagAdminToken,
+		EnvAllowNetwork:                 FlagAllowNetwork,
+		EnvBucket:                       FlagBucket,
+		EnvCacheEntries:                 FlagCacheEntries,
+		EnvCacheObjectSize:              FlagCacheObjectSize,
+		EnvCacheSize:                    FlagCacheSize,
+		EnvConfig:                       FlagConfig,
+		EnvConnections:                  FlagConnections,
+		EnvDSN:                          FlagDSN,
+		EnvFetchConfig:                  FlagFetchConfig,
+		EnvFetchConnectTimeout:          FlagFetchConnectTimeout,
+		EnvFetchTimeout:                 FlagFetchTimeout,
+		EnvHost:                         FlagHost,
+		EnvLogFormat:                    FlagLogFormat,
+		EnvMaxAge:                       FlagMaxAge,
+		EnvMaxFetchSize:                 FlagMaxFetchSize,
+		EnvMaxHeight:                    FlagMaxHeight,
+		EnvMaxPixels:                    FlagMaxPixels,
+		EnvMaxRedirects:                 FlagMaxRedirects,
+		EnvMaxWidth:                     FlagMaxWidth,
+		EnvMetricsPort:                  FlagMetricsPort,
+		EnvNaming:                       FlagNaming,
+		EnvOrigin:                       FlagOrigin,
+		EnvPort:                         FlagPort,
+		EnvPrefix:                       FlagPrefix,
+		EnvPresets:                      FlagPresets,
+		EnvPresetsOnly:                  FlagPresetsOnly,
+		EnvQueueSize:                    FlagQueueSize,
+		EnvQueueTimeout:                 FlagQueueTimeout,
+		EnvS3AccessKeyID:                FlagS3AccessKeyID,
+		EnvS3Endpoint:                   FlagS3Endpoint,
+		EnvS3Region:                     FlagS3Region,
+		EnvS3SecretAccessKey:            FlagS3SecretAccessKey,
+		EnvSaveQueueDir:                 FlagSaveQueueDir,
+		EnvSaveRetries:                  FlagSaveRetries,
+		EnvSaveRetryInterval:            FlagSaveRetryInterval,
+		EnvShard:                        FlagShard,
+		EnvShutdownTimeout:              FlagShutdownTimeout,
+		EnvSigningKey:                   FlagSigningKey,
+		EnvSourceCacheDir:               FlagSourceCacheDir,
+		EnvSourceCacheMaxAge:            FlagSourceCacheMaxAge,
+		EnvSourceCacheSize:              FlagSourceCacheSize,
+		EnvSourceCacheTTL:               FlagSourceCacheTTL,
+		EnvSourceRoot:                   FlagSourceRoot,
+		EnvTraceEndpoint:                FlagTraceEndpoint,
+		EnvTraceExporter:                FlagTraceExporter,
+		EnvVerbose:                      FlagVerbose,
+		EnvWorkers:                      FlagWorkers,
+		EnvWriteThrough:                 FlagWriteThrough,
+		EnvWriteThroughTimeout:          FlagWriteThroughTimeout,
 	}
-	EnvFlagMap = map[string]string{}
+	// Envs は EnvFlagMap の環境変数を優先する順に並べたもの。
+	// 同じフラグを設定する環境変数は、RESIZER_ で始まるものを優先する。
+	Envs []string
 )
 
 func init() {
-	for i, env := range Envs {
-		EnvFlagMap[env] = Flags[i]
+	for env := range EnvFlagMap {
+		Envs = append(Envs, env)
 	}
+	sort.Slice(Envs, func(i, j int) bool {
+		a, b := strings.HasPrefix(Envs[i], "RESIZER_"), strings.HasPrefix(Envs[j], "RESIZER_")
+		if a != b {
+			return a
+		}
+		return Envs[i] < Envs[j]
+	})
 }
 
 type Options struct {
-	ServiceAccount     ServiceAccount
-	Bucket             string
-	MaxHTTPConnections int
-	DataSourceName     string
-	AllowedHosts       Hosts
-	Port               int
-	ObjectPrefix       string
-	Verbose            bool
+	ServiceAccount      ServiceAccount
+	Bucket              string
+	CacheEntries        int
+	CacheSize           int64
+	CacheObjectSize     int64
+	ConfigFile          string
+	PrintConfig         bool
+	MaxHTTPConnections  int
+	Workers             int
+	QueueSize           int
+	QueueTimeout        time.Duration
+	ShutdownTimeout     time.Duration
+	SaveQueueDir        string
+	SaveRetries         int
+	SaveRetryInterval   time.Duration
+	WriteThrough        bool
+	WriteThroughTimeout time.Duration
+	AdminToken          Secret
+	DataSourceName      string
+	AllowedHosts        Hosts
+	AllowedNetworks     Networks
+	Origins             Origins
+	FetchConfigs        FetchConfigs
+	CacheMaxAge         time.Duration
+	Port                int
+	MetricsPort         int
+	ObjectPrefix        string
+	Naming              string
+	ShardDepth          int
+	SigningKeys         Keys
+	Presets             Presets
+	PresetsOnly         bool
+	SourceCacheDir      string
+	SourceCacheSize     int64
+	SourceCacheTTL      time.Duration
+	SourceCacheMaxAge   time.Duration
+	SourceRoot          string
+	S3Region            string
+	S3Endpoint          string
+	S3AccessKeyID       string
+	S3SecretAccessKey   Secret
+	FetchConnectTimeout time.Duration
+	FetchTimeout        time.Duration
+	MaxFetchSize        int64
+	MaxRedirects        int
+	MaxPixels           int64
+	MaxWidth            int
+	MaxHeight           int
+	Verbose             bool
+	LogFormat           string
+	TraceExporter       string
+	TraceEndpoint       string
 }
 
+// Parse は設定ファイル、環境変数、コマンドライン引数 args の順にオプションを読み込む。
+// 後から読み込んだ値が優先され、複数の値を持つオプションも置き換えられる。
 func (o *Options) Parse(args []string) error {
-	if v := os.Getenv(EnvGoogleAuthJSON); v != "" {
-		b := []byte(v)
-		if err := json.Unmarshal(b, &o.ServiceAccount); err != nil {
+	o.setDefaults()
+	fs := o.flagSet()
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	// 指定されたオプションを、より優先順位の低い値で上書きしないように記録する
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	for _, env := range Envs {
+		name := EnvFlagMap[env]
+		if v := os.Getenv(env); v != "" && !set[name] {
+			if err := fs.Set(name, v); err != nil {
+				return errors.Wrapf(err, "invalid value of %s", env)
+			}
+			set[name] = true
+		}
+	}
+	// 認証情報の JSON は、サービスアカウントのファイルが指定されていない場合に使用する
+	if authJSON := os.Getenv(EnvGoogleAuthJSON); authJSON != "" && !set[FlagAccount] {
+		if err := o.ServiceAccount.setJSON([]byte(authJSON)); err != nil {
 			return err
 		}
-		o.ServiceAccount.Path = filepath.Join(os.TempDir(), "resizer-google-auth.json")
-		if err := ioutil.WriteFile(o.ServiceAccount.Path, b, 0644); err != nil {
+		set[FlagAccount] = true
+	}
+
+	if o.ConfigFile != "" {
+		if err := loadConfig(fs, o.ConfigFile, set); err != nil {
 			return err
 		}
 	}
+	return o.validate()
+}
 
+// setDefaults は o のフィールドにデフォルト値を設定する。
+func (o *Options) setDefaults() {
+	o.CacheEntries = DefaultCacheEntries
+	o.CacheSize = DefaultCacheSize
+	o.MaxFetchSize = DefaultMaxFetchSize
+	o.MaxRedirects = DefaultMaxRedirects
+	o.MaxPixels = DefaultMaxPixels
+	o.QueueSize = DefaultQueueSize
+	o.QueueTimeout = DefaultQueueTimeout
+	o.ShutdownTimeout = DefaultShutdownTimeout
+	o.SaveRetries = DefaultSaveRetries
+	o.SaveRetryInterval = DefaultSaveRetryInterval
+	o.WriteThroughTimeout = DefaultWriteThroughTimeout
+	o.FetchConnectTimeout = DefaultFetchConnectTimeout
+	o.FetchTimeout = DefaultFetchTimeout
+	o.CacheMaxAge = DefaultCacheMaxAge
+	o.Port = 80
+	o.Naming = NamingDefault
+	o.SourceCacheTTL = DefaultSourceCacheTTL
+	o.SourceCacheMaxAge = DefaultSourceCacheMaxAge
+	o.LogFormat = LogFormatDefault
+	o.TraceEndpoint = DefaultTraceEndpoint
+}
+
+// flagSet は o のフィールドに値を設定する FlagSet を作成する。
+// フィールドの値は変更せず、各フラグのデフォルト値とする。
+func (o *Options) flagSet() *flag.FlagSet {
 	fs := flag.NewFlagSet("resizer", flag.ContinueOnError)
 	fs.Var(&o.ServiceAccount, "account", `Path to the file of Google service account JSON.`)
-	fs.StringVar(&o.Bucket, "bucket", "", `Bucket name of Google Cloud Storage to upload the resized image.`)
-	fs.IntVar(&o.MaxHTTPConnections, "connections", 0, `Max simultaneous connections to be accepted by server.
+	fs.StringVar(&o.Bucket, "bucket", o.Bucket, `Bucket name of Google Cloud Storage to upload the resized image.`)
+	fs.IntVar(&o.CacheEntries, "cache-entries", o.CacheEntries, `Max entries of in-process cache for resized images.
+         When 0 or less is specified, the number of entries isn't limited.
+         `)
+	fs.Int64Var(&o.CacheSize, "cache-size", o.CacheSize, `Max bytes of in-process cache for resized images.
+         When 0 or less is specified, the bytes aren't limited.
+         `)
+	fs.Int64Var(&o.CacheObjectSize, "cache-object-size", o.CacheObjectSize, `Max bytes of resized image data to be cached in process.
+         The cached data is responded instead of redirecting to the storage URL.
+         When 0 is specified, resized image data isn't cached.
+         `)
+	fs.StringVar(&o.ConfigFile, "config", o.ConfigFile, `Path to the configuration file in JSON, YAML or TOML.
+         The keys are the names of the options, and the file can express all options.
+         The values in the file are overridden by environment variables and flags.
+         `)
+	fs.Int64Var(&o.MaxFetchSize, "max-fetch-size", o.MaxFetchSize, `Max bytes of the source image to be fetched.
+         When 0 is specified, the size isn't limited.
+         `)
+	fs.IntVar(&o.MaxRedirects, "max-redirects", o.MaxRedirects, `Max number of redirects to follow when fetching the source image.
+         The host of each redirect must be allowed with -host.
+         `)
+	fs.Int64Var(&o.MaxPixels, "max-pixels", o.MaxPixels, `Max number of pixels (width * height) of the source image to be decoded.
+         The source image is rejected before decoding when it exceeds the limit.
+         When 0 is specified, the number of pixels isn't limited.
+         `)
+	fs.IntVar(&o.MaxWidth, "max-width", o.MaxWidth, `Max width of the source image to be decoded.
+         When 0 is specified, the width isn't limited.
+         `)
+	fs.IntVar(&o.MaxHeight, "max-height", o.MaxHeight, `Max height of the source image to be decoded.
+         When 0 is specified, the height isn't limited.
+         `)
+	fs.IntVar(&o.MaxHTTPConnections, "connections", o.MaxHTTPConnections, `Max simultaneous connections to be accepted by server.
          When 0 or less is specified, the number of connections isn't limited.
          `)
-	fs.StringVar(&o.DataSourceName, "dsn", "", `Data source name of database to store resizing information.`)
+	fs.IntVar(&o.Workers, "workers", o.Workers, `Max number of images to be decoded and resized simultaneously.
+         When 0 is specified, the number of CPUs is used.
+         `)
+	fs.IntVar(&o.QueueSize, "queue-size", o.QueueSize, `Max number of requests waiting for decoding or resizing.
+         When the queue is full, the request is rejected with 503 Service Unavailable.
+         When 0 is specified, the queue size isn't limited.
+         `)
+	fs.DurationVar(&o.QueueTimeout, "queue-timeout", o.QueueTimeout, `Timeout to wait for decoding or resizing in the queue.
+         When timed out, the request is rejected with 503 Service Unavailable.
+         When 0 is specified, waiting doesn't time out.
+         `)
+	fs.DurationVar(&o.ShutdownTimeout, "shutdown-timeout", o.ShutdownTimeout, `Timeout to finish the requests and the saves of resized images on SIGTERM or SIGINT.
+         The saves unfinished in this duration are abandoned.
+         `)
+	fs.StringVar(&o.SaveQueueDir, "save-queue-dir", o.SaveQueueDir, `Directory to record the saves of resized images until they are uploaded and stored in database.
+         The saves left in the directory are resumed on start.
+         When this value isn't specified, a directory in the temporary directory is used.
+         `)
+	fs.IntVar(&o.SaveRetries, "save-retries", o.SaveRetries, `Max number of retries of the failed save of the resized image.
+         The save failed more than this is moved to "failed" directory in -save-queue-dir.
+         When a negative value is specified, the save is retried until it succeeds.
+         `)
+	fs.DurationVar(&o.SaveRetryInterval, "save-retry-interval", o.SaveRetryInterval, `Interval to retry the failed save of the resized image.
+         The interval is doubled for each retry up to 5 minutes.
+         `)
+	fs.BoolVar(&o.WriteThrough, "write-through", o.WriteThrough, `Upload the resized image and store it in database before responding.
+         When the save fails, the resized image is responded and the save is retried in the background.
+         When this value isn't specified, the resized image is saved in the background after responding.
+         `)
+	fs.DurationVar(&o.WriteThroughTimeout, "write-through-timeout", o.WriteThroughTimeout, `Timeout to save the resized image before responding with -write-through.
+         When timed out, the resized image is responded and the save is retried in the background.
+         When 0 is specified, the save doesn't time out.
+         `)
+	fs.StringVar(&o.DataSourceName, "dsn", o.DataSourceName, `Data source name of database to store resizing information.`)
+	fs.Var(&o.FetchConfigs, "fetch-config", `Path to the JSON file of request configs to fetch the source image for each host pattern.
+         The config can have "headers", "username", "password", "bearer_token", "user_agent",
+         and "tls_cert" and "tls_key" as the paths of the client certificate and key.
+         The host patterns are the same as -host. The configs can also be written in the configuration file.
+         `)
+	fs.DurationVar(&o.FetchConnectTimeout, "fetch-connect-timeout", o.FetchConnectTimeout, `Timeout to connect to the host of the source image.
+         When 0 is specified, connecting doesn't time out.
+         `)
+	fs.DurationVar(&o.FetchTimeout, "fetch-timeout", o.FetchTimeout, `Timeout to fetch the source image including reading the body.
+         When 0 is specified, fetching doesn't time out.
+         `)
 	fs.Var(&o.AllowedHosts, "host", `Hosts of the image that is allowed to resize.
          When this value isn't specified, all hosts are allowed.
+         Patterns like "*.a.com" (subdomains), ".a.com" (a.com and subdomains),
+         "a.com:8080", "a.com:*" (any port), "a.com/images/" (path prefix)
+         and "~^img[0-9]+\.a\.com$" (regular expression) are available.
          Multiple hosts can be specified with:
              $ resizer -host a.com,b.com
-             $ resizer -host a.com -host b.com`)
-	fs.IntVar(&o.Port, "port", 80, `Port to be listened.
+             $ resizer -host a.com -host b.com
+         Commas in brackets of regular expressions like "{2,3}" don't separate hosts.`)
+	fs.Var(&o.AllowedNetworks, "allow-network", `Networks that are allowed to fetch the image from.
+         Private, loopback and link-local addresses (including cloud metadata servers)
+         are forbidden in default. Source images are fetched without HTTP_PROXY and HTTPS_PROXY
+         to check the addresses. Multiple networks can be specified with:
+             $ resizer -allow-network 10.0.0.0/8,192.168.0.1
+             $ resizer -allow-network 10.0.0.0/8 -allow-network 192.168.0.1`)
+	fs.Var(&o.Origins, "origin", `Named origins of the source image specified as "name=url".
+         The source image is requested with "origin" and "path" parameters instead of "url",
+         and the hosts of the origins are allowed without -host.
+         Headers and credentials for each origin can be specified in the configuration file.
+         Only "http" and "https" schemes are supported for origins.
+         Multiple origins can be specified with:
+             $ resizer -origin a=https://a.com/images/,b=https://b.com/
+             $ resizer -origin a=https://a.com/images/ -origin b=https://b.com/`)
+	fs.DurationVar(&o.CacheMaxAge, "max-age", o.CacheMaxAge, `Max age of the resized image in Cache-Control header.
          `)
-	fs.StringVar(&o.ObjectPrefix, "prefix", "", ``)
-	fs.BoolVar(&o.Verbose, "verbose", false, `Verbose output.
+	fs.IntVar(&o.Port, "port", o.Port, `Port to be listened.
          `)
-	for _, env := range Envs {
-		flag := EnvFlagMap[env]
-		if v := os.Getenv(env); v != "" {
-			fs.Set(flag, v)
-		}
+	fs.IntVar(&o.MetricsPort, "metrics-port", o.MetricsPort, `Port to serve metrics in Prometheus text format at /metrics.
+         When 0 is specified, metrics aren't served.
+         `)
+	fs.StringVar(&o.ObjectPrefix, "prefix", o.ObjectPrefix, ``)
+	fs.StringVar(&o.Naming, "naming", o.Naming, `How to name the object of the resized image. "random" or "hash".
+         When "hash" is specified, the object is named with the hash of normalized options,
+         so the same resized image is always stored as the same object.
+         `)
+	fs.IntVar(&o.ShardDepth, "shard", o.ShardDepth, `Depth of directories to shard objects named with "hash".
+         When 2 is specified, the object is stored as "<prefix>ab/cd/abcd....jpg".
+         `)
+	fs.Var(&o.Presets, "presets", `Path to the JSON file of named presets of resizing options.
+         A preset is specified with "preset" parameter, and can be overridden with explicit parameters.
+         `)
+	fs.BoolVar(&o.PresetsOnly, "presets-only", o.PresetsOnly, `Allow only requests with a preset and without explicit resizing parameters.
+         `)
+	fs.BoolVar(&o.PrintConfig, "print-config", o.PrintConfig, `Print the effective configuration as JSON with secrets redacted, and exit.
+         `)
+	fs.Var(&o.SigningKeys, "signing-key", `Secret keys to verify the signature of the request URL.
+         When this value is specified, the request URL must be signed with one of the keys.
+         Multiple keys can be specified to rotate keys with:
+             $ resizer -signing-key new,old
+             $ resizer -signing-key new -signing-key old`)
+	fs.StringVar(&o.SourceCacheDir, "source-cache-dir", o.SourceCacheDir, `Directory to cache source images.
+         When this value isn't specified, a directory in the temporary directory is used.
+         `)
+	fs.Int64Var(&o.SourceCacheSize, "source-cache-size", o.SourceCacheSize, `Max bytes of cached source images.
+         When 0 is specified, source images aren't cached and are fetched every time.
+         `)
+	fs.DurationVar(&o.SourceCacheTTL, "source-cache-ttl", o.SourceCacheTTL, `Duration to use a cached source image without revalidation.
+         The cached source image older than this is revalidated with conditional GET.
+         `)
+	fs.DurationVar(&o.SourceCacheMaxAge, "source-cache-max-age", o.SourceCacheMaxAge, `Duration to keep a cached source image which isn't used.
+         `)
+	fs.StringVar(&o.SourceRoot, "source-root", o.SourceRoot, `Directory of source images fetched with "file" scheme.
+         The path in the URL "file:///a/b.jpg" is resolved from this directory.
+         When this value isn't specified, "file" scheme isn't allowed.
+         `)
+	fs.StringVar(&o.S3Region, "s3-region", o.S3Region, `Region of Amazon S3 to fetch source images with "s3" scheme.
+         The bucket in the URL "s3://bucket/a/b.jpg" must be allowed with -host.
+         `)
+	fs.StringVar(&o.S3Endpoint, "s3-endpoint", o.S3Endpoint, `Endpoint of S3 compatible storage like "http://localhost:9000".
+         When this value is specified, the object is requested with path-style URL.
+         An internal address of the endpoint must be allowed with -allow-network.
+         `)
+	fs.StringVar(&o.S3AccessKeyID, "s3-access-key-id", o.S3AccessKeyID, `Access key ID to sign requests to S3.
+         When this value isn't specified, requests aren't signed.
+         `)
+	fs.Var(&o.S3SecretAccessKey, "s3-secret-access-key", `Secret access key to sign requests to S3.
+         `)
+	fs.Var(&o.AdminToken, "admin-token", `Bearer token to authorize requests to /admin/purge.
+         When this value isn't specified, /admin/purge isn't served.
+         `)
+	fs.BoolVar(&o.Verbose, "verbose", o.Verbose, `Verbose output.
+         When specified, debug logs are also written.
+         `)
+	fs.StringVar(&o.LogFormat, "log-format", o.LogFormat, `Format of logs. "logfmt" or "json".
+         `)
+	fs.StringVar(&o.TraceExporter, "trace-exporter", o.TraceExporter, `Exporter of tracing spans. "stdout" or "otlp".
+         When "otlp" is specified, spans are sent to -trace-endpoint with OTLP/HTTP in JSON.
+         When this value isn't specified, spans aren't recorded.
+         `)
+	fs.StringVar(&o.TraceEndpoint, "trace-endpoint", o.TraceEndpoint, `URL of the OTLP/HTTP collector to send tracing spans.
+         When the URL doesn't have path, spans are sent to /v1/traces.
+         `)
+	return fs
+}
+
+func (o *Options) validate() error {
+	switch o.Naming {
+	case NamingRandom, NamingHash:
+	default:
+		return fmt.Errorf("naming '%s' isn't allowed", o.Naming)
+	}
+	switch o.LogFormat {
+	case LogFormatJSON, LogFormatLogfmt:
+	default:
+		return fmt.Errorf("log format '%s' isn't allowed", o.LogFormat)
+	}
+	switch o.TraceExporter {
+	case TraceExporterNone, TraceExporterStdout, TraceExporterOTLP:
+	default:
+		return fmt.Errorf("trace exporter '%s' isn't allowed", o.TraceExporter)
+	}
+	if o.ShardDepth < 0 || o.ShardDepth > 16 {
+		return fmt.Errorf("shard depth %d isn't allowed", o.ShardDepth)
 	}
-	return fs.Parse(args)
+	return nil
 }
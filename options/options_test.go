@@ -4,6 +4,7 @@ import (
 	"os"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/minodisk/resizer/options"
 )
@@ -27,10 +28,10 @@ func TestOptions(t *testing.T) {
 				"-host", "a.com,b.com",
 			},
 			&options.Options{
-				AllowedHosts: []string{
+				AllowedHosts: options.MustHosts(
 					"a.com",
 					"b.com",
-				},
+				),
 				Port: 80,
 			},
 		},
@@ -42,10 +43,10 @@ func TestOptions(t *testing.T) {
 				"-host", "b.com",
 			},
 			&options.Options{
-				AllowedHosts: []string{
+				AllowedHosts: options.MustHosts(
 					"a.com",
 					"b.com",
-				},
+				),
 				Port: 80,
 			},
 		},
@@ -57,11 +58,11 @@ func TestOptions(t *testing.T) {
 				"-host", "c.com",
 			},
 			&options.Options{
-				AllowedHosts: []string{
+				AllowedHosts: options.MustHosts(
 					"a.com",
 					"b.com",
 					"c.com",
-				},
+				),
 				Port: 80,
 			},
 		},
@@ -102,7 +103,7 @@ func TestOptions(t *testing.T) {
 		},
 	} {
 		t.Run(c.name, func(t *testing.T) {
-			got := &options.Options{}
+			o := &options.Options{}
 
 			for _, k := range options.Envs {
 				os.Setenv(k, "")
@@ -110,9 +111,15 @@ func TestOptions(t *testing.T) {
 			for k, v := range c.envs {
 				os.Setenv(k, v)
 			}
-			if err := got.Parse(c.args); err != nil {
+			if err := o.Parse(c.args); err != nil {
 				t.Fatal(err)
 			}
+			// その他のオプションはデフォルト値のため、各リクエストのテストで確認する
+			got := &options.Options{
+				AllowedHosts: o.AllowedHosts,
+				Bucket:       o.Bucket,
+				Port:         o.Port,
+			}
 			if !reflect.DeepEqual(got, c.want) {
 				t.Error("ENVS:")
 				for _, k := range options.Envs {
@@ -123,3 +130,503 @@ func TestOptions(t *testing.T) {
 		})
 	}
 }
+
+// flagCase は環境変数 envs とコマンドライン引数 args を読み込んだ結果のテストケース。
+// err が true の場合は読み込みに失敗することを確認する。
+type flagCase struct {
+	name string
+	envs map[string]string
+	args []string
+	want options.Options
+	err  bool
+}
+
+// testFlags は各ケースを読み込み、pick で取り出したフィールドが want に一致するかを確認する。
+func testFlags(t *testing.T, cases []flagCase, pick func(o *options.Options) options.Options) {
+	if j := os.Getenv(options.EnvGoogleAuthJSON); j != "" {
+		os.Unsetenv(options.EnvGoogleAuthJSON)
+		defer os.Setenv(options.EnvGoogleAuthJSON, j)
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for _, k := range options.Envs {
+				os.Setenv(k, "")
+			}
+			for k, v := range c.envs {
+				os.Setenv(k, v)
+			}
+			defer func() {
+				for k := range c.envs {
+					os.Setenv(k, "")
+				}
+			}()
+			o := &options.Options{}
+			err := o.Parse(c.args)
+			if c.err {
+				if err == nil {
+					t.Errorf("should fail to parse %v", c.args)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := pick(o); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("\ngot:\n%+v\nwant:\n%+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestCacheMaxAgeFlag(t *testing.T) {
+	testFlags(t, []flagCase{
+		{
+			name: "default",
+			want: options.Options{CacheMaxAge: options.DefaultCacheMaxAge},
+		},
+		{
+			name: "env",
+			envs: map[string]string{options.EnvMaxAge: "1h"},
+			want: options.Options{CacheMaxAge: time.Hour},
+		},
+		{
+			name: "args",
+			args: []string{"-max-age", "0s"},
+			want: options.Options{CacheMaxAge: 0},
+		},
+	}, func(o *options.Options) options.Options {
+		return options.Options{CacheMaxAge: o.CacheMaxAge}
+	})
+}
+
+func TestNamingFlags(t *testing.T) {
+	testFlags(t, []flagCase{
+		{
+			name: "default",
+			want: options.Options{Naming: options.NamingRandom},
+		},
+		{
+			name: "hash with shard",
+			envs: map[string]string{options.EnvNaming: options.NamingHash},
+			args: []string{"-shard", "2"},
+			want: options.Options{Naming: options.NamingHash, ShardDepth: 2},
+		},
+		{
+			name: "unknown naming",
+			args: []string{"-naming", "foo"},
+			err:  true,
+		},
+		{
+			name: "too deep shard",
+			args: []string{"-naming", "hash", "-shard", "17"},
+			err:  true,
+		},
+	}, func(o *options.Options) options.Options {
+		return options.Options{Naming: o.Naming, ShardDepth: o.ShardDepth}
+	})
+}
+
+func TestCacheFlags(t *testing.T) {
+	testFlags(t, []flagCase{
+		{
+			name: "default",
+			want: options.Options{
+				CacheEntries: options.DefaultCacheEntries,
+				CacheSize:    options.DefaultCacheSize,
+			},
+		},
+		{
+			name: "args",
+			envs: map[string]string{options.EnvCacheEntries: "10"},
+			args: []string{
+				"-cache-size", "1024",
+				"-cache-object-size", "512",
+			},
+			want: options.Options{
+				CacheEntries:    10,
+				CacheSize:       1024,
+				CacheObjectSize: 512,
+			},
+		},
+	}, func(o *options.Options) options.Options {
+		return options.Options{
+			CacheEntries:    o.CacheEntries,
+			CacheSize:       o.CacheSize,
+			CacheObjectSize: o.CacheObjectSize,
+		}
+	})
+}
+
+func TestSourceCacheFlags(t *testing.T) {
+	testFlags(t, []flagCase{
+		{
+			name: "default",
+			want: options.Options{
+				SourceCacheTTL:    options.DefaultSourceCacheTTL,
+				SourceCacheMaxAge: options.DefaultSourceCacheMaxAge,
+			},
+		},
+		{
+			name: "args",
+			envs: map[string]string{options.EnvSourceCacheDir: "/tmp/sources"},
+			args: []string{
+				"-source-cache-size", "1024",
+				"-source-cache-ttl", "1m",
+				"-source-cache-max-age", "2h",
+			},
+			want: options.Options{
+				SourceCacheDir:    "/tmp/sources",
+				SourceCacheSize:   1024,
+				SourceCacheTTL:    time.Minute,
+				SourceCacheMaxAge: 2 * time.Hour,
+			},
+		},
+	}, func(o *options.Options) options.Options {
+		return options.Options{
+			SourceCacheDir:    o.SourceCacheDir,
+			SourceCacheSize:   o.SourceCacheSize,
+			SourceCacheTTL:    o.SourceCacheTTL,
+			SourceCacheMaxAge: o.SourceCacheMaxAge,
+		}
+	})
+}
+
+func TestFetchFlags(t *testing.T) {
+	testFlags(t, []flagCase{
+		{
+			name: "default",
+			want: options.Options{
+				FetchConnectTimeout: options.DefaultFetchConnectTimeout,
+				FetchTimeout:        options.DefaultFetchTimeout,
+				MaxFetchSize:        options.DefaultMaxFetchSize,
+				MaxRedirects:        options.DefaultMaxRedirects,
+			},
+		},
+		{
+			name: "args",
+			envs: map[string]string{options.EnvMaxFetchSize: "0"},
+			args: []string{
+				"-fetch-connect-timeout", "1s",
+				"-fetch-timeout", "2s",
+				"-max-redirects", "0",
+			},
+			want: options.Options{
+				FetchConnectTimeout: time.Second,
+				FetchTimeout:        2 * time.Second,
+			},
+		},
+	}, func(o *options.Options) options.Options {
+		return options.Options{
+			FetchConnectTimeout: o.FetchConnectTimeout,
+			FetchTimeout:        o.FetchTimeout,
+			MaxFetchSize:        o.MaxFetchSize,
+			MaxRedirects:        o.MaxRedirects,
+		}
+	})
+}
+
+func TestSigningKeyFlag(t *testing.T) {
+	testFlags(t, []flagCase{
+		{
+			name: "default",
+			want: options.Options{},
+		},
+		{
+			name: "rotated keys",
+			args: []string{"-signing-key", "new,old"},
+			want: options.Options{SigningKeys: options.Keys{"new", "old"}},
+		},
+	}, func(o *options.Options) options.Options {
+		return options.Options{SigningKeys: o.SigningKeys}
+	})
+}
+
+func TestSourceFlags(t *testing.T) {
+	testFlags(t, []flagCase{
+		{
+			name: "default",
+			want: options.Options{},
+		},
+		{
+			name: "args",
+			envs: map[string]string{options.EnvAWSRegion: "us-east-1"},
+			args: []string{
+				"-source-root", "/var/images",
+				"-s3-endpoint", "http://localhost:9000",
+				"-s3-secret-access-key", "s3cr3t",
+			},
+			want: options.Options{
+				SourceRoot:        "/var/images",
+				S3Region:          "us-east-1",
+				S3Endpoint:        "http://localhost:9000",
+				S3SecretAccessKey: "s3cr3t",
+			},
+		},
+	}, func(o *options.Options) options.Options {
+		return options.Options{
+			SourceRoot:        o.SourceRoot,
+			S3Region:          o.S3Region,
+			S3Endpoint:        o.S3Endpoint,
+			S3SecretAccessKey: o.S3SecretAccessKey,
+		}
+	})
+}
+
+func TestDecodeLimitFlags(t *testing.T) {
+	testFlags(t, []flagCase{
+		{
+			name: "default",
+			want: options.Options{MaxPixels: options.DefaultMaxPixels},
+		},
+		{
+			name: "args",
+			envs: map[string]string{options.EnvMaxPixels: "0"},
+			args: []string{
+				"-max-width", "4096",
+				"-max-height", "2048",
+			},
+			want: options.Options{MaxWidth: 4096, MaxHeight: 2048},
+		},
+	}, func(o *options.Options) options.Options {
+		return options.Options{
+			MaxPixels: o.MaxPixels,
+			MaxWidth:  o.MaxWidth,
+			MaxHeight: o.MaxHeight,
+		}
+	})
+}
+
+func TestQueueFlags(t *testing.T) {
+	testFlags(t, []flagCase{
+		{
+			name: "default",
+			want: options.Options{
+				QueueSize:    options.DefaultQueueSize,
+				QueueTimeout: options.DefaultQueueTimeout,
+			},
+		},
+		{
+			name: "args",
+			envs: map[string]string{options.EnvWorkers: "4"},
+			args: []string{
+				"-queue-size", "0",
+				"-queue-timeout", "1s",
+			},
+			want: options.Options{Workers: 4, QueueTimeout: time.Second},
+		},
+	}, func(o *options.Options) options.Options {
+		return options.Options{
+			Workers:      o.Workers,
+			QueueSize:    o.QueueSize,
+			QueueTimeout: o.QueueTimeout,
+		}
+	})
+}
+
+func TestMetricsPortFlag(t *testing.T) {
+	testFlags(t, []flagCase{
+		{
+			name: "default",
+			want: options.Options{},
+		},
+		{
+			name: "env",
+			envs: map[string]string{options.EnvMetricsPort: "9100"},
+			want: options.Options{MetricsPort: 9100},
+		},
+	}, func(o *options.Options) options.Options {
+		return options.Options{MetricsPort: o.MetricsPort}
+	})
+}
+
+func TestLogFlags(t *testing.T) {
+	testFlags(t, []flagCase{
+		{
+			name: "default",
+			want: options.Options{LogFormat: options.LogFormatLogfmt},
+		},
+		{
+			name: "json",
+			envs: map[string]string{options.EnvLogFormat: options.LogFormatJSON},
+			args: []string{"-verbose"},
+			want: options.Options{LogFormat: options.LogFormatJSON, Verbose: true},
+		},
+		{
+			name: "unknown format",
+			args: []string{"-log-format", "text"},
+			err:  true,
+		},
+	}, func(o *options.Options) options.Options {
+		return options.Options{LogFormat: o.LogFormat, Verbose: o.Verbose}
+	})
+}
+
+func TestTraceFlags(t *testing.T) {
+	testFlags(t, []flagCase{
+		{
+			name: "default",
+			want: options.Options{TraceEndpoint: options.DefaultTraceEndpoint},
+		},
+		{
+			name: "otlp",
+			envs: map[string]string{options.EnvOTLPEndpoint: "http://collector:4318"},
+			args: []string{"-trace-exporter", options.TraceExporterOTLP},
+			want: options.Options{
+				TraceExporter: options.TraceExporterOTLP,
+				TraceEndpoint: "http://collector:4318",
+			},
+		},
+		{
+			name: "unknown exporter",
+			args: []string{"-trace-exporter", "jaeger"},
+			err:  true,
+		},
+	}, func(o *options.Options) options.Options {
+		return options.Options{
+			TraceExporter: o.TraceExporter,
+			TraceEndpoint: o.TraceEndpoint,
+		}
+	})
+}
+
+func TestShutdownTimeoutFlag(t *testing.T) {
+	testFlags(t, []flagCase{
+		{
+			name: "default",
+			want: options.Options{ShutdownTimeout: options.DefaultShutdownTimeout},
+		},
+		{
+			name: "args",
+			args: []string{"-shutdown-timeout", "5s"},
+			want: options.Options{ShutdownTimeout: 5 * time.Second},
+		},
+	}, func(o *options.Options) options.Options {
+		return options.Options{ShutdownTimeout: o.ShutdownTimeout}
+	})
+}
+
+func TestSaveFlags(t *testing.T) {
+	testFlags(t, []flagCase{
+		{
+			name: "default",
+			want: options.Options{
+				SaveRetries:       options.DefaultSaveRetries,
+				SaveRetryInterval: options.DefaultSaveRetryInterval,
+			},
+		},
+		{
+			name: "args",
+			envs: map[string]string{options.EnvSaveQueueDir: "/tmp/saves"},
+			args: []string{
+				"-save-retries", "-1",
+				"-save-retry-interval", "3s",
+			},
+			want: options.Options{
+				SaveQueueDir:      "/tmp/saves",
+				SaveRetries:       -1,
+				SaveRetryInterval: 3 * time.Second,
+			},
+		},
+	}, func(o *options.Options) options.Options {
+		return options.Options{
+			SaveQueueDir:      o.SaveQueueDir,
+			SaveRetries:       o.SaveRetries,
+			SaveRetryInterval: o.SaveRetryInterval,
+		}
+	})
+}
+
+func TestWriteThroughFlags(t *testing.T) {
+	testFlags(t, []flagCase{
+		{
+			name: "default",
+			want: options.Options{WriteThroughTimeout: options.DefaultWriteThroughTimeout},
+		},
+		{
+			name: "args",
+			envs: map[string]string{options.EnvWriteThrough: "true"},
+			args: []string{"-write-through-timeout", "0s"},
+			want: options.Options{WriteThrough: true},
+		},
+	}, func(o *options.Options) options.Options {
+		return options.Options{
+			WriteThrough:        o.WriteThrough,
+			WriteThroughTimeout: o.WriteThroughTimeout,
+		}
+	})
+}
+
+func TestAdminTokenFlag(t *testing.T) {
+	testFlags(t, []flagCase{
+		{
+			name: "default",
+			want: options.Options{},
+		},
+		{
+			name: "env",
+			envs: map[string]string{options.EnvAdminToken: "t0k3n"},
+			want: options.Options{AdminToken: "t0k3n"},
+		},
+	}, func(o *options.Options) options.Options {
+		return options.Options{AdminToken: o.AdminToken}
+	})
+}
+
+func TestEnvPrecedence(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		envs map[string]string
+		args []string
+		want string
+	}{
+		{
+			"only alias",
+			map[string]string{
+				options.EnvAWSAccessKeyID: "aws",
+			},
+			[]string{},
+			"aws",
+		},
+		{
+			"prefixed env over alias",
+			map[string]string{
+				options.EnvAWSAccessKeyID: "aws",
+				options.EnvS3AccessKeyID:  "resizer",
+			},
+			[]string{},
+			"resizer",
+		},
+		{
+			"args over envs",
+			map[string]string{
+				options.EnvAWSAccessKeyID: "aws",
+				options.EnvS3AccessKeyID:  "resizer",
+			},
+			[]string{
+				"-s3-access-key-id", "arg",
+			},
+			"arg",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			for _, k := range options.Envs {
+				os.Setenv(k, "")
+			}
+			for k, v := range c.envs {
+				os.Setenv(k, v)
+			}
+			defer func() {
+				for k := range c.envs {
+					os.Setenv(k, "")
+				}
+			}()
+			o := &options.Options{}
+			if err := o.Parse(c.args); err != nil {
+				t.Fatal(err)
+			}
+			if o.S3AccessKeyID != c.want {
+				t.Errorf("S3AccessKeyID should be %s, but got %s", c.want, o.S3AccessKeyID)
+			}
+		})
+	}
+}
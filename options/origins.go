@@ -0,0 +1,162 @@
+package options
+
+import (
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Origin は名前を付けて参照する元画像の取得先。
+// 元画像の URL は URL にリクエストされたパスを連結したものになる。
+// 取得先へのリクエストには FetchConfig の設定を使用する。
+// 取得先の URL のスキームは http または https に限る。
+type Origin struct {
+	URL string `json:"url"`
+	FetchConfig
+
+	base *url.URL
+}
+
+// Base は取得先の URL を解析した値を返す。
+func (o Origin) Base() *url.URL {
+	u := *o.base
+	return &u
+}
+
+// UnmarshalJSON は取得先を URL の文字列またはオブジェクトから読み込む。
+func (o *Origin) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return o.parse(Origin{URL: s})
+	}
+	type Alias Origin
+	var alias Alias
+	if err := json.Unmarshal(data, &alias); err != nil {
+		return err
+	}
+	return o.parse(Origin(alias))
+}
+
+func (o *Origin) parse(origin Origin) error {
+	u, err := url.Parse(origin.URL)
+	if err != nil {
+		return errors.Wrapf(err, "invalid origin URL '%s'", origin.URL)
+	}
+	switch u.Scheme {
+	case "http", "https":
+	case "gs", "s3", "file":
+		// ストレージのバケットやファイルは URL で直接指定させ、取得先としては扱わない
+		return errors.Errorf("origin URL '%s' isn't supported: scheme '%s' can be used only with url", origin.URL, u.Scheme)
+	default:
+		return errors.Errorf("invalid origin URL '%s'", origin.URL)
+	}
+	if u.Host == "" {
+		return errors.Errorf("invalid origin URL '%s'", origin.URL)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return errors.Errorf("origin URL '%s' shouldn't have query or fragment", origin.URL)
+	}
+	if err := origin.validate(); err != nil {
+		return errors.Wrapf(err, "invalid origin '%s'", origin.URL)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	u.RawPath = ""
+	origin.base = u
+	*o = origin
+	return nil
+}
+
+// Match は URL u が取得先の URL 以下を指しているかを判定する。
+func (o Origin) Match(u *url.URL) bool {
+	return u.Scheme == o.base.Scheme &&
+		strings.EqualFold(u.Host, o.base.Host) &&
+		strings.HasPrefix(u.Path, o.base.Path)
+}
+
+// Origins は名前をキーにした元画像の取得先の一覧。
+type Origins map[string]Origin
+
+func (origins *Origins) String() string {
+	names := make([]string, 0, len(*origins))
+	for name := range *origins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Get は秘密の値を伏せた取得先の一覧を返す。
+func (origins *Origins) Get() interface{} {
+	m := make(map[string]Origin, len(*origins))
+	for name, o := range *origins {
+		o.FetchConfig = o.FetchConfig.Redact()
+		m[name] = o
+	}
+	return m
+}
+
+// Set は name=url 形式で指定された取得先を追加する。
+//
+//   products=https://assets.internal.example.com/products/,users=https://example.com/users/
+func (origins *Origins) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 {
+			return errors.Errorf("origin should be specified as 'name=url', but got '%s'", v)
+		}
+		var o Origin
+		if err := o.parse(Origin{URL: kv[1]}); err != nil {
+			return err
+		}
+		if err := origins.add(kv[0], o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (origins *Origins) setJSON(b []byte) error {
+	m := map[string]Origin{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	for name, o := range m {
+		if err := origins.add(name, o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (origins *Origins) add(name string, o Origin) error {
+	if name == "" {
+		return errors.New("origin name shouldn't be empty")
+	}
+	if *origins == nil {
+		*origins = Origins{}
+	}
+	(*origins)[name] = o
+	return nil
+}
+
+// Lookup は URL u を含む取得先を返す。
+// 複数の取得先が含む場合は、最も長いパスの取得先を返す。
+func (origins Origins) Lookup(u *url.URL) (Origin, bool) {
+	var found Origin
+	ok := false
+	for _, o := range origins {
+		if o.Match(u) && (!ok || len(o.base.Path) > len(found.base.Path)) {
+			found, ok = o, true
+		}
+	}
+	return found, ok
+}
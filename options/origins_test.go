@@ -0,0 +1,49 @@
+package options_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/minodisk/resizer/options"
+)
+
+func TestOrigins(t *testing.T) {
+	var origins options.Origins
+	if err := origins.Set("a=https://a.com/images,b=https://a.com/images/b/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := origins.Set("c=ftp://a.com/"); err == nil {
+		t.Errorf("origin with scheme ftp should be invalid")
+	}
+	for _, v := range []string{"d=gs://bucket/", "e=s3://bucket/", "f=file:///images/"} {
+		if err := origins.Set(v); err == nil {
+			t.Errorf("origin %s shouldn't be supported", v)
+		}
+	}
+	if err := origins.Set("https://a.com/"); err == nil {
+		t.Errorf("origin without name should be invalid")
+	}
+
+	for _, c := range []struct {
+		url  string
+		want string
+	}{
+		{"https://a.com/images/1.jpg", "https://a.com/images/"},
+		{"https://A.com/images/b/1.jpg", "https://a.com/images/b/"},
+		{"https://a.com/imagesfoo/1.jpg", ""},
+		{"http://a.com/images/1.jpg", ""},
+		{"https://b.com/images/1.jpg", ""},
+	} {
+		u, err := url.Parse(c.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got string
+		if o, ok := origins.Lookup(u); ok {
+			got = o.Base().String()
+		}
+		if got != c.want {
+			t.Errorf("%s: got %q, want %q", c.url, got, c.want)
+		}
+	}
+}
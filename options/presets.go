@@ -0,0 +1,78 @@
+package options
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Preset は名前を付けたリサイズのオプション。
+// ゼロ値のフィールドは指定されていないものとして扱う。
+type Preset struct {
+	Width   int    `json:"width,omitempty"`
+	Height  int    `json:"height,omitempty"`
+	Method  string `json:"method,omitempty"`
+	Format  string `json:"format,omitempty"`
+	Quality int    `json:"quality,omitempty"`
+}
+
+// Presets は名前をキーにしたプリセットの一覧。
+type Presets map[string]Preset
+
+func (ps *Presets) String() string {
+	names := make([]string, 0, len(*ps))
+	for name := range *ps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func (ps *Presets) Get() interface{} {
+	return map[string]Preset(*ps)
+}
+
+// Set はパス path の JSON ファイルからプリセットを読み込む。
+// ファイルは名前をキーにしたオブジェクトで記述する。
+//
+//   {
+//     "thumb": {"width": 320, "height": 320, "method": "cover", "quality": 80}
+//   }
+func (ps *Presets) Set(path string) error {
+	if path == "" {
+		return errors.New("path to presets JSON isn't specified")
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "fail to read the file of presets JSON")
+	}
+	if err := ps.UnmarshalJSON(b); err != nil {
+		return errors.Wrap(err, "fail to unmarshal JSON")
+	}
+	return nil
+}
+
+func (ps *Presets) setJSON(b []byte) error {
+	return ps.UnmarshalJSON(b)
+}
+
+// UnmarshalJSON は JSON のオブジェクトからプリセットを読み込み、既存のプリセットに追加する。
+func (ps *Presets) UnmarshalJSON(data []byte) error {
+	m := map[string]Preset{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	if *ps == nil {
+		*ps = Presets{}
+	}
+	for name, p := range m {
+		if name == "" {
+			return errors.New("preset name shouldn't be empty")
+		}
+		(*ps)[name] = p
+	}
+	return nil
+}
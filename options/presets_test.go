@@ -0,0 +1,48 @@
+package options_test
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/minodisk/resizer/options"
+)
+
+func TestPresetsSet(t *testing.T) {
+	f, err := ioutil.TempFile("", "resizer-presets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(`{
+  "thumb": {"width": 320, "height": 320, "method": "cover", "quality": 80},
+  "wide": {"width": 1280, "format": "png"}
+}`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	var ps options.Presets
+	if err := ps.Set(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	want := options.Presets{
+		"thumb": options.Preset{
+			Width:   320,
+			Height:  320,
+			Method:  "cover",
+			Quality: 80,
+		},
+		"wide": options.Preset{
+			Width:  1280,
+			Format: "png",
+		},
+	}
+	if !reflect.DeepEqual(ps, want) {
+		t.Errorf("\ngot:\n%+v\nwant:\n%+v", ps, want)
+	}
+	if got, want := ps.String(), "thumb, wide"; got != want {
+		t.Errorf("got: %s, want: %s", got, want)
+	}
+}
@@ -0,0 +1,21 @@
+package options
+
+// Secret は出力しないように伏せる秘密の文字列。
+type Secret string
+
+func (s *Secret) String() string {
+	if *s == "" {
+		return ""
+	}
+	return Redacted
+}
+
+func (s *Secret) Set(value string) error {
+	*s = Secret(value)
+	return nil
+}
+
+// Get は設定を出力する際の値として伏せた値を返す。
+func (s *Secret) Get() interface{} {
+	return s.String()
+}
@@ -0,0 +1,30 @@
+package pool
+
+import (
+	"fmt"
+	"time"
+)
+
+type QueueFullError struct {
+	Size int64
+}
+
+func NewQueueFullError(size int64) QueueFullError {
+	return QueueFullError{size}
+}
+
+func (err QueueFullError) Error() string {
+	return fmt.Sprintf("too many requests are waiting for processing: queue size %d", err.Size)
+}
+
+type QueueTimeoutError struct {
+	Timeout time.Duration
+}
+
+func NewQueueTimeoutError(timeout time.Duration) QueueTimeoutError {
+	return QueueTimeoutError{timeout}
+}
+
+func (err QueueTimeoutError) Error() string {
+	return fmt.Sprintf("waiting for processing timed out in %s", err.Timeout)
+}
@@ -0,0 +1,112 @@
+// Package pool では CPU 負荷の高い処理の同時実行数を制限する仕組みが実装されています。
+//
+// デコードやリサイズを CPU 数程度の並列度で実行し、
+// 処理しきれないリクエストは上限のある待ち行列で待たせます。
+package pool
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// Stats は Pool の利用状況を表す。
+type Stats struct {
+	// Workers は同時に実行できる処理の数。
+	Workers int
+	// Running は実行中の処理の数。
+	Running int64
+	// Waiting は実行を待っている処理の数。
+	Waiting int64
+	// Rejected は待ち行列が一杯で拒否した処理の数。
+	Rejected int64
+	// TimedOut は待ち時間が上限を超えて中止した処理の数。
+	TimedOut int64
+}
+
+// Pool は同時に実行する処理の数を制限する。
+// 複数の goroutine から同時に使用できる。
+type Pool struct {
+	running  int64
+	waiting  int64
+	rejected int64
+	timedOut int64
+
+	slots     chan struct{}
+	queueSize int64
+	timeout   time.Duration
+}
+
+// New は同時に workers 個の処理を実行する Pool を作成する。
+// workers が 0 以下の場合は CPU の数を使用する。
+// queueSize が 0 以下の場合は待ち行列の長さを、timeout が 0 以下の場合は待ち時間を制限しない。
+func New(workers, queueSize int, timeout time.Duration) *Pool {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	return &Pool{
+		slots:     make(chan struct{}, workers),
+		queueSize: int64(queueSize),
+		timeout:   timeout,
+	}
+}
+
+// Do は処理 fn を実行できるようになるまで待ってから実行し、そのエラーを返す。
+// 待ち行列が一杯の場合は QueueFullError を、
+// 待ち時間が上限を超えた場合は QueueTimeoutError を返し fn を実行しない。
+func (p *Pool) Do(fn func() error) error {
+	if err := p.acquire(); err != nil {
+		return err
+	}
+	atomic.AddInt64(&p.running, 1)
+	defer func() {
+		atomic.AddInt64(&p.running, -1)
+		<-p.slots
+	}()
+	return fn()
+}
+
+func (p *Pool) acquire() error {
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if n := atomic.AddInt64(&p.waiting, 1); p.queueSize > 0 && n > p.queueSize {
+		atomic.AddInt64(&p.waiting, -1)
+		atomic.AddInt64(&p.rejected, 1)
+		return NewQueueFullError(p.queueSize)
+	}
+	defer atomic.AddInt64(&p.waiting, -1)
+
+	if p.timeout <= 0 {
+		p.slots <- struct{}{}
+		return nil
+	}
+	t := time.NewTimer(p.timeout)
+	defer t.Stop()
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	case <-t.C:
+		atomic.AddInt64(&p.timedOut, 1)
+		return NewQueueTimeoutError(p.timeout)
+	}
+}
+
+// Timeout は待ち時間の上限を返す。
+func (p *Pool) Timeout() time.Duration {
+	return p.timeout
+}
+
+// Stats は利用状況を返す。
+func (p *Pool) Stats() Stats {
+	return Stats{
+		Workers:  cap(p.slots),
+		Running:  atomic.LoadInt64(&p.running),
+		Waiting:  atomic.LoadInt64(&p.waiting),
+		Rejected: atomic.LoadInt64(&p.rejected),
+		TimedOut: atomic.LoadInt64(&p.timedOut),
+	}
+}
@@ -0,0 +1,113 @@
+package pool_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/minodisk/resizer/pool"
+)
+
+func TestDoLimitsConcurrency(t *testing.T) {
+	t.Parallel()
+
+	p := pool.New(2, 0, 0)
+	var (
+		wg      sync.WaitGroup
+		running int64
+		max     int64
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := p.Do(func() error {
+				n := atomic.AddInt64(&running, 1)
+				for {
+					m := atomic.LoadInt64(&max)
+					if n <= m || atomic.CompareAndSwapInt64(&max, m, n) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+				atomic.AddInt64(&running, -1)
+				return nil
+			})
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if max != 2 {
+		t.Errorf("max concurrency should be 2, but got %d", max)
+	}
+	if s := p.Stats(); s.Running != 0 || s.Waiting != 0 {
+		t.Errorf("pool should be idle, but got %+v", s)
+	}
+}
+
+func TestDoRejects(t *testing.T) {
+	t.Parallel()
+
+	for _, c := range []struct {
+		name      string
+		queueSize int
+		timeout   time.Duration
+		want      error
+	}{
+		{
+			"queue is full",
+			1,
+			0,
+			pool.NewQueueFullError(1),
+		},
+		{
+			"waiting timed out",
+			0,
+			10 * time.Millisecond,
+			pool.NewQueueTimeoutError(10 * time.Millisecond),
+		},
+	} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := pool.New(1, c.queueSize, c.timeout)
+			started := make(chan struct{})
+			release := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				p.Do(func() error {
+					close(started)
+					<-release
+					return nil
+				})
+				close(done)
+			}()
+			<-started
+			if c.queueSize > 0 {
+				// 待ち行列を埋める
+				go p.Do(func() error { return nil })
+				for p.Stats().Waiting < int64(c.queueSize) {
+					time.Sleep(time.Millisecond)
+				}
+			}
+
+			called := false
+			err := p.Do(func() error {
+				called = true
+				return nil
+			})
+			close(release)
+			<-done
+			if err != c.want {
+				t.Errorf("got: %v, want: %v", err, c.want)
+			}
+			if called {
+				t.Errorf("function shouldn't be called")
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package processor
+
+import "fmt"
+
+type TooManyPixelsError struct {
+	Width  int
+	Height int
+}
+
+func NewTooManyPixelsError(width, height int) TooManyPixelsError {
+	return TooManyPixelsError{width, height}
+}
+
+func (err TooManyPixelsError) Error() string {
+	return fmt.Sprintf("source image of %d * %d pixels is too large to decode", err.Width, err.Height)
+}
@@ -4,6 +4,7 @@
 package processor
 
 import (
+	"context"
 	"fmt"
 	"image"
 	"image/draw"
@@ -11,51 +12,38 @@ import (
 	"image/jpeg"
 	"image/png"
 	"io"
-	"log"
 	"os"
-	"sync"
 
 	"github.com/minodisk/orientation"
 	"github.com/minodisk/resizer/input"
+	"github.com/minodisk/resizer/logger"
+	"github.com/minodisk/resizer/options"
 	"github.com/minodisk/resizer/storage"
 	"github.com/nfnt/resize"
 	"github.com/pkg/errors"
 )
 
-var (
-	mutex sync.Mutex
-)
-
-type Processor struct{}
-
-func New() *Processor {
-	return &Processor{}
+type Processor struct {
+	maxPixels int64
+	maxWidth  int
+	maxHeight int
+	log       *logger.Logger
 }
 
-func (p *Processor) Process(path string, w io.Writer, f storage.Image) (*image.Point, error) {
-	c := make(chan Result)
-	go p.process(&mutex, c, path, w, f)
-	for res := range c {
-		return res.Point, res.Error
+func New(o *options.Options) *Processor {
+	return &Processor{
+		maxPixels: o.MaxPixels,
+		maxWidth:  o.MaxWidth,
+		maxHeight: o.MaxHeight,
+		log:       logger.Default(),
 	}
-	return nil, nil
 }
 
-type Result struct {
-	Point *image.Point
-	Error error
-}
-
-func (p *Processor) process(m *sync.Mutex, c chan Result, path string, w io.Writer, f storage.Image) {
-	m.Lock()
-	defer m.Unlock()
-
-	i, err := p.Preprocess(path)
-	if err != nil {
-		c <- Result{nil, err}
-	}
-	pt, err := p.Resize(i, w, f)
-	c <- Result{pt, err}
+// WithContext は Context ctx の Logger にログを出力する Processor を返す。
+func (p *Processor) WithContext(ctx context.Context) *Processor {
+	c := *p
+	c.log = logger.FromContext(ctx)
+	return &c
 }
 
 // Preprocess load image and EXIF from file at filename.
@@ -68,6 +56,13 @@ func (self *Processor) Preprocess(filename string) (image.Image, error) {
 	}
 	defer src.Close()
 
+	if err := self.check(src); err != nil {
+		return nil, err
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, errors.Wrap(err, "fail to seek file")
+	}
+
 	dst, err := orientation.Apply(src)
 	if err != nil {
 		if err, ok := err.(*orientation.DecodeError); ok {
@@ -78,6 +73,21 @@ func (self *Processor) Preprocess(filename string) (image.Image, error) {
 	return dst, nil
 }
 
+// check はデコードする前にヘッダーから画像のサイズを読み取り、
+// 上限を超えている場合は TooManyPixelsError を返す。
+func (self *Processor) check(r io.Reader) error {
+	c, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return errors.Wrap(err, "fail to decode config of image")
+	}
+	if (self.maxWidth > 0 && c.Width > self.maxWidth) ||
+		(self.maxHeight > 0 && c.Height > self.maxHeight) ||
+		(self.maxPixels > 0 && int64(c.Width)*int64(c.Height) > self.maxPixels) {
+		return NewTooManyPixelsError(c.Width, c.Height)
+	}
+	return nil
+}
+
 // Load decodes image from file at filename.
 // It returns decoded image, the format of image, and any error occurred.
 func Load(filename string) (image.Image, string, error) {
@@ -93,10 +103,27 @@ func Load(filename string) (image.Image, string, error) {
 	return src, format, nil
 }
 
-// Process writes resized i to w with options in f.
+// Resize writes resized i to w with options in f.
 // Returns the size of resized i and any error occurred.
 func (self *Processor) Resize(i image.Image, w io.Writer, f storage.Image) (*image.Point, error) {
-	log.Printf("dest image: %+v\n", f)
+	ir, err := self.Transform(i, f)
+	if err != nil {
+		return nil, err
+	}
+	if err := self.Encode(w, ir, f); err != nil {
+		return nil, err
+	}
+	size := ir.Bounds().Size()
+	return &size, nil
+}
+
+// Transform は画像 i を f のメソッドとサイズでリサイズした画像を返す。
+func (self *Processor) Transform(i image.Image, f storage.Image) (image.Image, error) {
+	self.log.Debug("resize image",
+		"method", f.ValidatedMethod,
+		"dest_width", f.DestWidth, "dest_height", f.DestHeight,
+		"canvas_width", f.CanvasWidth, "canvas_height", f.CanvasHeight,
+	)
 
 	var ir image.Image
 	switch f.ValidatedMethod {
@@ -111,25 +138,20 @@ func (self *Processor) Resize(i image.Image, w io.Writer, f storage.Image) (*ima
 		draw.Draw(dst, cr, src, image.Point{int((f.DestWidth - f.CanvasWidth) / 2), int((f.DestHeight - f.CanvasHeight) / 2)}, draw.Src)
 		ir = dst
 	}
+	return ir, nil
+}
 
+// Encode は画像 i を f のフォーマットと品質でエンコードして w に書き込む。
+func (self *Processor) Encode(w io.Writer, i image.Image, f storage.Image) error {
 	switch f.ValidatedFormat {
 	default:
-		return nil, fmt.Errorf("Unsupported format: %s", f.ValidatedFormat)
+		return fmt.Errorf("Unsupported format: %s", f.ValidatedFormat)
 	case input.FormatJPEG:
-		if err := jpeg.Encode(w, ir, &jpeg.Options{Quality: int(f.ValidatedQuality)}); err != nil {
-			return nil, err
-		}
+		return jpeg.Encode(w, i, &jpeg.Options{Quality: int(f.ValidatedQuality)})
 	case input.FormatPNG:
 		e := png.Encoder{CompressionLevel: png.DefaultCompression}
-		if err := e.Encode(w, ir); err != nil {
-			return nil, err
-		}
+		return e.Encode(w, i)
 	case input.FormatGIF:
-		if err := gif.Encode(w, ir, &gif.Options{NumColors: 256}); err != nil {
-			return nil, err
-		}
+		return gif.Encode(w, i, &gif.Options{NumColors: 256})
 	}
-
-	size := ir.Bounds().Size()
-	return &size, nil
 }
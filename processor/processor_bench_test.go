@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/minodisk/resizer/input"
+	"github.com/minodisk/resizer/options"
 	"github.com/minodisk/resizer/processor"
 	"github.com/minodisk/resizer/storage"
 )
@@ -15,19 +16,23 @@ func (w NopWriter) Write(p []byte) (n int, err error) {
 }
 
 func process(path string) error {
-	p := processor.New()
+	p := processor.New(&options.Options{})
 	input := input.Input{
 		URL:   "http://example.com/test.jpg",
 		Width: 800,
 	}
-	input, err := input.Validate([]string{"example.com"})
+	input, err := input.Validate(options.MustHosts("example.com"))
 	i, err := storage.NewImage(input)
 	if err != nil {
 		return err
 	}
 
+	pixels, err := p.Preprocess(path)
+	if err != nil {
+		return err
+	}
 	var w NopWriter
-	if _, err = p.Process(path, &w, i); err != nil {
+	if _, err = p.Resize(pixels, &w, i); err != nil {
 		return err
 	}
 	return nil
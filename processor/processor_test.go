@@ -4,14 +4,18 @@ import (
 	"bytes"
 	"fmt"
 	"image"
+	imagepng "image/png"
+	"io/ioutil"
 	"math"
 	"os"
 	"testing"
 
 	"github.com/minodisk/resizer/input"
+	"github.com/minodisk/resizer/options"
 	"github.com/minodisk/resizer/processor"
 	"github.com/minodisk/resizer/storage"
 	"github.com/minodisk/resizer/testutil"
+	"github.com/pkg/errors"
 )
 
 const (
@@ -125,7 +129,7 @@ func evalPixels(t *testing.T, i image.Image, p image.Point, colors []int) {
 func eval(t *testing.T, path string, f storage.Image, size image.Point, colors []int) string {
 	var b []byte
 	w := bytes.NewBuffer(b)
-	p := processor.New()
+	p := processor.New(&options.Options{})
 	f.ValidatedWidth *= u
 	f.ValidatedHeight *= u
 	pixels, err := p.Preprocess(path)
@@ -370,3 +374,43 @@ func TestFormatThumbnail(t *testing.T) {
 		0, 0, 1, 1, 1, 1, 1, 1, 0, 0,
 	})
 }
+
+func TestPreprocessLimits(t *testing.T) {
+	f, err := ioutil.TempFile("", "resizer-processor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if err := imagepng.Encode(f, image.NewGray(image.Rect(0, 0, 100, 50))); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	for _, c := range []struct {
+		name    string
+		options options.Options
+		wantErr bool
+	}{
+		{"unlimited", options.Options{}, false},
+		{"within limits", options.Options{MaxPixels: 5000, MaxWidth: 100, MaxHeight: 50}, false},
+		{"too many pixels", options.Options{MaxPixels: 4999}, true},
+		{"too wide", options.Options{MaxWidth: 99}, true},
+		{"too high", options.Options{MaxHeight: 49}, true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			i, err := processor.New(&c.options).Preprocess(f.Name())
+			if !c.wantErr {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := i.Bounds().Size(); got != image.Pt(100, 50) {
+					t.Errorf("got size %v", got)
+				}
+				return
+			}
+			if _, ok := errors.Cause(err).(processor.TooManyPixelsError); !ok {
+				t.Errorf("should return TooManyPixelsError, but got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/minodisk/resizer/options"
+	"github.com/minodisk/resizer/server"
+	"github.com/pkg/errors"
+)
+
+// purge は起動しているサーバーに元画像の URL などに一致するリサイズ画像の削除を要求し、
+// 削除したリサイズ画像のオブジェクト名と元画像の URL を出力する。
+//
+//   $ resizer purge -endpoint http://localhost:8080 -url 'https://a.com/foo.jpg'
+//   $ resizer purge -prefix 'https://a.com/images/' -dry-run
+func purge(args []string) error {
+	fs := flag.NewFlagSet("resizer purge", flag.ContinueOnError)
+	endpoint := fs.String("endpoint", "http://localhost", `URL of the server to purge resized images.`)
+	token := fs.String("token", os.Getenv(options.EnvAdminToken), `Bearer token to authorize the request.
+         In default, `+options.EnvAdminToken+` is used.`)
+	u := fs.String("url", "", `Purge resized images of the source image at the URL.`)
+	prefix := fs.String("prefix", "", `Purge resized images of the source images at URLs starting with the prefix.`)
+	hash := fs.String("hash", "", `Purge resized images with the hash of normalized options.`)
+	dryRun := fs.Bool("dry-run", false, `List resized images to be purged without purging.`)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: resizer purge [options]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *token == "" {
+		return errors.New("token to authorize isn't specified")
+	}
+
+	form := url.Values{}
+	for key, value := range map[string]string{"url": *u, "prefix": *prefix, "hash": *hash} {
+		if value != "" {
+			form.Set(key, value)
+		}
+	}
+	if len(form) != 1 {
+		fs.Usage()
+		return errors.New("one of -url, -prefix and -hash must be specified")
+	}
+	form.Set("dry_run", strconv.FormatBool(*dryRun))
+
+	req, err := http.NewRequest("POST", strings.TrimSuffix(*endpoint, "/")+server.PathPurge, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+*token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "fail to request to purge")
+	}
+	defer resp.Body.Close()
+
+	r := server.PurgeResult{}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return errors.Wrapf(err, "fail to read response: %s", resp.Status)
+	}
+	for _, i := range r.Images {
+		fmt.Printf("%s\t%s\n", i.Filename, i.URL)
+	}
+	if r.Error != "" {
+		return errors.Errorf("fail to purge: %s", r.Error)
+	}
+	if r.DryRun {
+		fmt.Fprintf(os.Stderr, "%d resized images would be purged\n", len(r.Images))
+	} else {
+		fmt.Fprintf(os.Stderr, "%d resized images are purged\n", len(r.Images))
+	}
+	return nil
+}
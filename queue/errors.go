@@ -0,0 +1,11 @@
+package queue
+
+type ClosedError struct{}
+
+func NewClosedError() ClosedError {
+	return ClosedError{}
+}
+
+func (err ClosedError) Error() string {
+	return "queue is closed"
+}
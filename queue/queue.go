@@ -0,0 +1,328 @@
+// Package queue ではディスクに記録して失敗しても再試行する処理の待ち行列が実装されています。
+//
+// 積まれた処理は完了するまでディレクトリに記録され、
+// プロセスが再起動しても次に開いた時に再開します。
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	extJob    = ".json"
+	dirFailed = "failed"
+
+	// maxInterval は再試行の間隔の上限。
+	maxInterval = 5 * time.Minute
+)
+
+// Job はキューに積まれた処理を表す。
+type Job struct {
+	ID        string          `json:"id"`
+	Data      json.RawMessage `json:"data"`
+	Attempts  int             `json:"attempts"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Decode は処理の内容を v に読み込む。
+func (j Job) Decode(v interface{}) error {
+	return json.Unmarshal(j.Data, v)
+}
+
+// Handler は処理 j を実行する。エラーを返すと間隔を空けて再試行される。
+type Handler func(ctx context.Context, j Job) error
+
+// Stats は Queue の利用状況を表す。
+type Stats struct {
+	// Depth は完了していない処理の数。
+	Depth int64
+	// Running は実行中の処理の数。
+	Running int64
+	// Completed は完了した処理の数。
+	Completed int64
+	// Retries は再試行した回数。
+	Retries int64
+	// Failures は再試行の上限を超えて諦めた処理の数。
+	Failures int64
+}
+
+// Queue は処理をディレクトリに記録してから実行し、失敗した処理を指数的に間隔を空けて再試行する。
+// 複数の goroutine から同時に使用できる。
+type Queue struct {
+	depth     int64
+	running   int64
+	completed int64
+	retried   int64
+	failures  int64
+
+	dir      string
+	retries  int
+	interval time.Duration
+	handler  Handler
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	closing chan struct{}
+	wg      sync.WaitGroup
+
+	mu      sync.Mutex
+	closed  bool
+	resumed bool
+	loaded  []Job
+}
+
+// Open はディレクトリ dir に処理を記録し、処理を h で実行する Queue を作成する。
+// 失敗した処理は最初に interval、以降は倍ずつ間隔を空けて retries 回まで再試行し、
+// それでも失敗した処理は dir の failed ディレクトリに移す。
+// retries が負の場合は成功するまで再試行する。
+// ディレクトリに残っている処理は読み込み、Resume を呼ぶと再開する。
+func Open(dir string, retries int, interval time.Duration, h Handler) (*Queue, error) {
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "resizer-queue")
+	}
+	if err := os.MkdirAll(filepath.Join(dir, dirFailed), 0777); err != nil {
+		return nil, errors.Wrap(err, "fail to create queue directory")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &Queue{
+		dir:      filepath.Clean(dir),
+		retries:  retries,
+		interval: interval,
+		handler:  h,
+		ctx:      ctx,
+		cancel:   cancel,
+		closing:  make(chan struct{}),
+	}
+	if err := q.load(); err != nil {
+		cancel()
+		return nil, err
+	}
+	return q, nil
+}
+
+// load はディレクトリに残っている処理を古い順に読み込む。
+// 読み込めない処理は failed ディレクトリに移す。
+func (q *Queue) load() error {
+	tmps, err := filepath.Glob(filepath.Join(q.dir, "tmp-*"))
+	if err != nil {
+		return err
+	}
+	for _, tmp := range tmps {
+		os.Remove(tmp)
+	}
+	// ID は作成した時刻から始まるため、名前順は作成順になる
+	files, err := filepath.Glob(filepath.Join(q.dir, "*"+extJob))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return errors.Wrap(err, "fail to read job")
+		}
+		j := Job{}
+		if err := json.Unmarshal(b, &j); err != nil || j.ID != strings.TrimSuffix(filepath.Base(file), extJob) {
+			os.Rename(file, filepath.Join(q.dir, dirFailed, filepath.Base(file)))
+			q.failures++
+			continue
+		}
+		q.loaded = append(q.loaded, j)
+		q.depth++
+	}
+	return nil
+}
+
+// Resume は Open した時にディレクトリに残っていた処理を再開する。
+func (q *Queue) Resume() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed || q.resumed {
+		return
+	}
+	q.resumed = true
+	for _, j := range q.loaded {
+		q.start(j, nil)
+	}
+	q.loaded = nil
+}
+
+// Push は値 v を JSON にした処理をディレクトリに記録してから非同期に実行する。
+// 処理が完了するか再試行の上限を超えると、done が nil でなければ最後のエラーで呼ばれる。
+// Close の後は記録した処理を実行せずに ClosedError を返し、処理は次に Open した時に再開する。
+func (q *Queue) Push(v interface{}, done func(error)) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+	j := Job{ID: id, Data: data, CreatedAt: time.Now()}
+	if err := q.write(j); err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	atomic.AddInt64(&q.depth, 1)
+	if q.closed {
+		return NewClosedError()
+	}
+	q.start(j, done)
+	return nil
+}
+
+// start は処理 j を実行する goroutine を起動する。q.mu を保持して呼ぶ。
+func (q *Queue) start(j Job, done func(error)) {
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		finished, err := q.run(j)
+		if finished && done != nil {
+			done(err)
+		}
+	}()
+}
+
+// run は処理 j を完了するか再試行の上限を超えるまで実行し、最後のエラーを返す。
+// 途中で Queue が閉じられた場合は処理をディレクトリに残して finished に false を返す。
+func (q *Queue) run(j Job) (finished bool, err error) {
+	for {
+		atomic.AddInt64(&q.running, 1)
+		err = q.handler(q.ctx, j)
+		atomic.AddInt64(&q.running, -1)
+		if err == nil {
+			os.Remove(q.path(j.ID))
+			atomic.AddInt64(&q.depth, -1)
+			atomic.AddInt64(&q.completed, 1)
+			return true, nil
+		}
+		if q.ctx.Err() != nil {
+			return false, err
+		}
+
+		j.Attempts++
+		if q.retries >= 0 && j.Attempts > q.retries {
+			os.Rename(q.path(j.ID), filepath.Join(q.dir, dirFailed, j.ID+extJob))
+			atomic.AddInt64(&q.depth, -1)
+			atomic.AddInt64(&q.failures, 1)
+			return true, err
+		}
+		// 再起動した後も試行回数を引き継ぐ
+		q.write(j)
+
+		t := time.NewTimer(q.backoff(j.Attempts))
+		select {
+		case <-t.C:
+			atomic.AddInt64(&q.retried, 1)
+		case <-q.closing:
+			t.Stop()
+			return false, err
+		}
+	}
+}
+
+// backoff は attempts 回失敗した後に再試行するまでの間隔を返す。
+func (q *Queue) backoff(attempts int) time.Duration {
+	d := q.interval
+	for i := 1; i < attempts && d < maxInterval; i++ {
+		d *= 2
+	}
+	if d > maxInterval {
+		d = maxInterval
+	}
+	return d
+}
+
+// write は処理 j を一時ファイルに書き込んでから置き換える。
+func (q *Queue) write(j Job) error {
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(q.dir, "tmp-")
+	if err != nil {
+		return errors.Wrap(err, "fail to write job")
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "fail to write job")
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "fail to write job")
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "fail to write job")
+	}
+	if err := os.Rename(tmp.Name(), q.path(j.ID)); err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "fail to write job")
+	}
+	return nil
+}
+
+func (q *Queue) path(id string) string {
+	return filepath.Join(q.dir, id+extJob)
+}
+
+// Close は処理の受け付けと再試行の待機を止め、実行中の処理の完了を ctx が終了するまで待つ。
+// 完了していない処理はディレクトリに残り、次に Open した時に再開する。
+// 残った処理の数を返す。
+func (q *Queue) Close(ctx context.Context) int64 {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.closing)
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	q.cancel()
+	return atomic.LoadInt64(&q.depth)
+}
+
+// Stats は Queue の利用状況を返す。
+func (q *Queue) Stats() Stats {
+	return Stats{
+		Depth:     atomic.LoadInt64(&q.depth),
+		Running:   atomic.LoadInt64(&q.running),
+		Completed: atomic.LoadInt64(&q.completed),
+		Retries:   atomic.LoadInt64(&q.retried),
+		Failures:  atomic.LoadInt64(&q.failures),
+	}
+}
+
+// newID は作成した時刻の順に並ぶ処理の ID を作成する。
+func newID() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%019d-%s", time.Now().UnixNano(), hex.EncodeToString(b)), nil
+}
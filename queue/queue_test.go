@@ -0,0 +1,182 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/minodisk/resizer/queue"
+)
+
+type payload struct {
+	Name string `json:"name"`
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "queue-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func jobs(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestPush(t *testing.T) {
+	t.Parallel()
+
+	for _, c := range []struct {
+		name     string
+		retries  int
+		fails    int64
+		wantErr  bool
+		want     queue.Stats
+		wantFail int
+	}{
+		{
+			"succeed at first",
+			3,
+			0,
+			false,
+			queue.Stats{Completed: 1},
+			0,
+		},
+		{
+			"succeed after retries",
+			3,
+			2,
+			false,
+			queue.Stats{Completed: 1, Retries: 2},
+			0,
+		},
+		{
+			"give up after retries",
+			2,
+			10,
+			true,
+			queue.Stats{Retries: 2, Failures: 1},
+			1,
+		},
+	} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := tempDir(t)
+			defer os.RemoveAll(dir)
+
+			var attempts int64
+			q, err := queue.Open(dir, c.retries, time.Millisecond, func(ctx context.Context, j queue.Job) error {
+				p := payload{}
+				if err := j.Decode(&p); err != nil {
+					t.Errorf("fail to decode: %v", err)
+				}
+				if p.Name != "foo" {
+					t.Errorf("payload should be foo, but got %q", p.Name)
+				}
+				if atomic.AddInt64(&attempts, 1) <= c.fails {
+					return errors.New("fail")
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			done := make(chan error, 1)
+			if err := q.Push(payload{"foo"}, func(err error) { done <- err }); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case err := <-done:
+				if (err != nil) != c.wantErr {
+					t.Errorf("error should be returned: %t, but got %v", c.wantErr, err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("job isn't finished")
+			}
+
+			if got := q.Stats(); got != c.want {
+				t.Errorf("stats should be %+v, but got %+v", c.want, got)
+			}
+			if got := jobs(t, dir); len(got) != 0 {
+				t.Errorf("finished job should be removed, but got %v", got)
+			}
+			if got := jobs(t, filepath.Join(dir, "failed")); len(got) != c.wantFail {
+				t.Errorf("failed jobs should be %d, but got %v", c.wantFail, got)
+			}
+			if n := q.Close(context.Background()); n != 0 {
+				t.Errorf("no job should remain, but got %d", n)
+			}
+		})
+	}
+}
+
+func TestResume(t *testing.T) {
+	t.Parallel()
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	failed := make(chan struct{}, 1)
+	q, err := queue.Open(dir, -1, time.Hour, func(ctx context.Context, j queue.Job) error {
+		failed <- struct{}{}
+		return errors.New("fail")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Push(payload{"foo"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	<-failed
+	if n := q.Close(context.Background()); n != 1 {
+		t.Errorf("1 job should remain, but got %d", n)
+	}
+	if err := q.Push(payload{"bar"}, nil); err != queue.NewClosedError() {
+		t.Errorf("closed queue should reject job, but got %v", err)
+	}
+
+	names := make(chan string, 2)
+	q, err = queue.Open(dir, 0, time.Millisecond, func(ctx context.Context, j queue.Job) error {
+		p := payload{}
+		if err := j.Decode(&p); err != nil {
+			return err
+		}
+		names <- p.Name
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := q.Stats(); s.Depth != 2 {
+		t.Errorf("2 jobs should be loaded, but got %+v", s)
+	}
+	q.Resume()
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case n := <-names:
+			got[n] = true
+		case <-time.After(time.Second):
+			t.Fatal("job isn't resumed")
+		}
+	}
+	if !got["foo"] || !got["bar"] {
+		t.Errorf("foo and bar should be resumed, but got %v", got)
+	}
+	if n := q.Close(context.Background()); n != 0 {
+		t.Errorf("no job should remain, but got %d", n)
+	}
+}
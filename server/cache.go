@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+
+	"github.com/minodisk/resizer/storage"
+	"github.com/minodisk/resizer/trace"
+)
+
+// imageSize はキャッシュするレコードのおおよそのバイト数。
+const imageSize = 512
+
+func validatedKey(i storage.Image) string {
+	return "validated:" + i.ValidatedHash
+}
+
+func normalizedKey(i storage.Image) string {
+	return "normalized:" + i.NormalizedHash
+}
+
+func bytesKey(i storage.Image) string {
+	return "bytes:" + i.NormalizedHash
+}
+
+func pendingValidatedKey(i storage.Image) string {
+	return "pending:validated:" + i.ValidatedHash
+}
+
+func pendingNormalizedKey(i storage.Image) string {
+	return "pending:normalized:" + i.NormalizedHash
+}
+
+// findValidated はバリデート済みのオプションでリサイズをしたキャッシュを探す。
+// メモリにキャッシュされていなければDBを探す。
+func (h *Handler) findValidated(ctx context.Context, i storage.Image) (storage.Image, bool) {
+	ctx, span := trace.Start(ctx, "lookup")
+	span.SetAttributes("options", "validated")
+	defer span.End()
+	if v, ok := h.Cache.Get(validatedKey(i)); ok {
+		h.metrics.lookup("validated", true)
+		span.SetAttributes("hit", "memory")
+		return v.(storage.Image), true
+	}
+	c, ok := h.Storage.WithContext(ctx).FindByValidated(i)
+	h.metrics.lookup("validated", ok)
+	if ok {
+		span.SetAttributes("hit", "database")
+	}
+	if ok {
+		h.Cache.Add(validatedKey(i), c, imageSize+int64(len(c.ValidatedURL)))
+	}
+	return c, ok
+}
+
+// findNormalized は正規化済みのオプションでリサイズをしたキャッシュを探す。
+// メモリにキャッシュされていなければDBを探す。
+func (h *Handler) findNormalized(ctx context.Context, i storage.Image) (storage.Image, bool) {
+	ctx, span := trace.Start(ctx, "lookup")
+	span.SetAttributes("options", "normalized")
+	defer span.End()
+	if v, ok := h.Cache.Get(normalizedKey(i)); ok {
+		h.metrics.lookup("normalized", true)
+		span.SetAttributes("hit", "memory")
+		return v.(storage.Image), true
+	}
+	c, ok := h.Storage.WithContext(ctx).FindByNormalized(i)
+	h.metrics.lookup("normalized", ok)
+	if ok {
+		span.SetAttributes("hit", "database")
+	}
+	if ok {
+		h.Cache.Add(normalizedKey(i), c, imageSize+int64(len(c.ValidatedURL)))
+	}
+	return c, ok
+}
+
+// addCache は保存したリサイズ画像のレコードをメモリにキャッシュする。
+// データ b が設定された上限以下のサイズであればデータもキャッシュする。
+func (h *Handler) addCache(i storage.Image, b []byte) {
+	size := imageSize + int64(len(i.ValidatedURL))
+	h.Cache.Add(validatedKey(i), i, size)
+	h.Cache.Add(normalizedKey(i), i, size)
+	if b != nil && int64(len(b)) <= h.Options.CacheObjectSize {
+		h.Cache.Add(bytesKey(i), b, int64(len(b)))
+	}
+}
+
+// addPending は保存が完了するまで、リサイズ画像の結果 r をメモリにキャッシュする。
+// キャッシュの上限を超えて破棄された場合、後続のリクエストは改めてリサイズする。
+func (h *Handler) addPending(r *result) {
+	size := imageSize + int64(len(r.image.ValidatedURL)) + int64(len(r.bytes))
+	h.Cache.Add(pendingValidatedKey(r.image), r, size)
+	h.Cache.Add(pendingNormalizedKey(r.image), r, size)
+}
+
+// findPending はキー key の保存が完了していないリサイズ画像の結果を探す。
+func (h *Handler) findPending(key string) (*result, bool) {
+	v, ok := h.Cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return v.(*result), true
+}
+
+// removePending はリサイズ画像 i の保存を待つ結果をキャッシュから破棄する。
+func (h *Handler) removePending(i storage.Image) {
+	h.Cache.Remove(pendingValidatedKey(i))
+	h.Cache.Remove(pendingNormalizedKey(i))
+}
+
+// invalidate はリサイズ画像 i に関するキャッシュを破棄する。
+func (h *Handler) invalidate(i storage.Image) {
+	h.Cache.Remove(validatedKey(i))
+	h.Cache.Remove(normalizedKey(i))
+	h.Cache.Remove(bytesKey(i))
+}
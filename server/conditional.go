@@ -0,0 +1,62 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minodisk/resizer/storage"
+)
+
+// setCacheHeaders はリサイズ画像のキャッシュに関するヘッダーを設定する。
+func setCacheHeaders(header http.Header, i storage.Image, maxAge time.Duration) {
+	header.Set("ETag", quoteETag(i.ETag))
+	if !i.CreatedAt.IsZero() {
+		header.Set("Last-Modified", i.CreatedAt.UTC().Format(http.TimeFormat))
+	}
+	header.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int64(maxAge/time.Second)))
+}
+
+// notModified は条件付きリクエストに対して 304 Not Modified を返すべきかを判定する。
+// If-None-Match が指定されている場合は If-Modified-Since より優先して評価する。
+func notModified(req *http.Request, etag string, modified time.Time) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		return matchETag(inm, etag)
+	}
+	ims := req.Header.Get("If-Modified-Since")
+	if ims == "" || modified.IsZero() {
+		return false
+	}
+	t, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(t)
+}
+
+// matchETag は If-None-Match の値に etag が含まれているかを弱い比較で判定する。
+func matchETag(inm, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	for _, t := range strings.Split(inm, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" {
+			return true
+		}
+		t = strings.TrimPrefix(t, "W/")
+		if t == quoteETag(etag) {
+			return true
+		}
+	}
+	return false
+}
+
+func quoteETag(etag string) string {
+	return strconv.Quote(etag)
+}
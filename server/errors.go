@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/minodisk/resizer/fetcher"
+	"github.com/minodisk/resizer/pool"
+	"github.com/minodisk/resizer/processor"
+	"github.com/minodisk/resizer/signature"
+	"github.com/pkg/errors"
+)
+
+// statusCode はエラー err に応じたレスポンスのステータスコードを返す。
+func statusCode(err error) int {
+	switch errors.Cause(err).(type) {
+	case fetcher.StatusError, fetcher.TooManyRedirectsError, fetcher.InvalidRedirectHostError:
+		return http.StatusBadGateway
+	case fetcher.FileNotFoundError:
+		return http.StatusNotFound
+	case fetcher.ForbiddenAddressError, fetcher.ForbiddenFileError, signature.MissingSignatureError, signature.InvalidSignatureError, signature.ExpiredError:
+		return http.StatusForbidden
+	case fetcher.TimeoutError:
+		return http.StatusGatewayTimeout
+	case fetcher.TooLargeError:
+		return http.StatusRequestEntityTooLarge
+	case fetcher.InvalidContentTypeError:
+		return http.StatusUnsupportedMediaType
+	case processor.TooManyPixelsError:
+		return http.StatusUnprocessableEntity
+	case pool.QueueFullError, pool.QueueTimeoutError:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// retryAfter は待ち時間の上限 timeout から Retry-After ヘッダーの秒数を返す。
+func retryAfter(timeout time.Duration) string {
+	sec := int((timeout + time.Second - 1) / time.Second)
+	if sec < 1 {
+		sec = 1
+	}
+	return strconv.Itoa(sec)
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/minodisk/resizer/version"
+)
+
+const (
+	pathHealthz = "/healthz"
+	pathReadyz  = "/readyz"
+	pathVersion = "/version"
+
+	// readyTimeout は依存するサービスに接続できるかを調べる際のタイムアウト。
+	readyTimeout = 3 * time.Second
+)
+
+// serveProbe はリクエスト req が死活監視やビルドの情報のパスであればレスポンスして true を返す。
+func (h *Handler) serveProbe(resp http.ResponseWriter, req *http.Request) bool {
+	switch req.URL.Path {
+	case pathHealthz:
+		resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		resp.Write([]byte("ok\n"))
+	case pathReadyz:
+		h.serveReadyz(resp, req)
+	case pathVersion:
+		writeJSON(resp, http.StatusOK, version.Get())
+	default:
+		return false
+	}
+	return true
+}
+
+// serveReadyz はデータベースとストレージに接続できるかを調べてレスポンスする。
+// どちらかに接続できない場合は 503 Service Unavailable をレスポンスする。
+func (h *Handler) serveReadyz(resp http.ResponseWriter, req *http.Request) {
+	ctx, cancel := context.WithTimeout(req.Context(), readyTimeout)
+	defer cancel()
+
+	checks := map[string]func(context.Context) error{
+		"database": h.Storage.Ping,
+		"storage":  h.Uploader.Ping,
+	}
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]string, len(checks))
+		ready   = true
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) error) {
+			defer wg.Done()
+			err := check(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				results[name] = err.Error()
+				ready = false
+				return
+			}
+			results[name] = "ok"
+		}(name, check)
+	}
+	wg.Wait()
+
+	code := http.StatusOK
+	status := "ok"
+	if !ready {
+		code = http.StatusServiceUnavailable
+		status = "unavailable"
+	}
+	writeJSON(resp, code, map[string]interface{}{
+		"status": status,
+		"checks": results,
+	})
+}
+
+func writeJSON(resp http.ResponseWriter, code int, v interface{}) {
+	resp.Header().Set("Content-Type", "application/json; charset=utf-8")
+	resp.WriteHeader(code)
+	json.NewEncoder(resp).Encode(v)
+}
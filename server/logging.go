@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/minodisk/resizer/logger"
+)
+
+const (
+	headerRequestID = "X-Request-Id"
+	// maxRequestIDLen は引き継ぐリクエスト ID の最大の長さ。
+	maxRequestIDLen = 64
+)
+
+// requestID はリクエスト req の ID を返す。
+// X-Request-Id ヘッダーに有効な ID が指定されていればその ID を引き継ぎ、
+// そうでなければ新しく生成する。
+func requestID(req *http.Request) string {
+	id := req.Header.Get(headerRequestID)
+	if id == "" || len(id) > maxRequestIDLen {
+		return logger.NewRequestID()
+	}
+	for _, c := range id {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '-' || c == '_' || c == '.') {
+			return logger.NewRequestID()
+		}
+	}
+	return id
+}
+
+type requestIDKey struct{}
+
+// withRequestID はリクエストの ID id を持つ Context を返す。
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// requestIDFromContext は Context ctx が持つリクエストの ID を返す。
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// accessLog はリクエスト req とレスポンス resp のアクセスログを l に出力する。
+func accessLog(l *logger.Logger, req *http.Request, resp *responseWriter, d time.Duration) {
+	l.Info("access",
+		"method", req.Method,
+		"uri", req.URL.RequestURI(),
+		"status", resp.code(),
+		"bytes", resp.bytes,
+		"format", resp.format,
+		"duration", d,
+		"remote_addr", req.RemoteAddr,
+		"user_agent", req.UserAgent(),
+		"referer", req.Referer(),
+	)
+}
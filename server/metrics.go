@@ -0,0 +1,184 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/minodisk/resizer/cache"
+	"github.com/minodisk/resizer/fetcher"
+	"github.com/minodisk/resizer/flight"
+	"github.com/minodisk/resizer/pool"
+	"github.com/minodisk/resizer/queue"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// リサイズ処理の段階。
+const (
+	stageFetch  = "fetch"
+	stageDecode = "decode"
+	stageResize = "resize"
+	stageEncode = "encode"
+	stageUpload = "upload"
+)
+
+// serverMetrics はサーバーのメトリクス。
+type serverMetrics struct {
+	registry  *prometheus.Registry
+	requests  *prometheus.CounterVec
+	lookups   *prometheus.CounterVec
+	durations *prometheus.HistogramVec
+	bytes     *prometheus.CounterVec
+}
+
+// newMetrics はメトリクスを作成し、LRU c、Group g、Fetcher f、Pool p と保存のキュー q の利用状況を登録する。
+func newMetrics(c *cache.LRU, g *flight.Group, f *fetcher.Fetcher, p *pool.Pool, q *queue.Queue) *serverMetrics {
+	r := prometheus.NewRegistry()
+	m := &serverMetrics{
+		registry: r,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "resizer_requests_total",
+			Help: "Number of requests by status code and format.",
+		}, []string{"status", "format"}),
+		lookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "resizer_cache_lookups_total",
+			Help: "Number of lookups for resized images with validated or normalized options.",
+		}, []string{"options", "result"}),
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "resizer_stage_duration_seconds",
+			Help:    "Duration of each stage of resizing in seconds.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"stage"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "resizer_bytes_total",
+			Help: "Bytes of fetched source images (in) and responded bodies (out).",
+		}, []string{"direction"}),
+	}
+	r.MustRegister(m.requests, m.lookups, m.durations, m.bytes, prometheus.NewGoCollector())
+
+	counter := func(name, help string, fn func() float64) {
+		r.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{Name: name, Help: help}, fn))
+	}
+	gauge := func(name, help string, fn func() float64) {
+		r.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: name, Help: help}, fn))
+	}
+	gauge("resizer_saves_in_flight", "Number of resized images being saved.", func() float64 {
+		return float64(q.Stats().Running)
+	})
+	gauge("resizer_save_queue_depth", "Number of saves recorded in the queue and not finished.", func() float64 {
+		return float64(q.Stats().Depth)
+	})
+	counter("resizer_save_retries_total", "Number of retries of failed saves.", func() float64 {
+		return float64(q.Stats().Retries)
+	})
+	counter("resizer_save_failures_total", "Number of saves given up after retries.", func() float64 {
+		return float64(q.Stats().Failures)
+	})
+
+	counter("resizer_flight_leads_total", "Number of resizing actually processed.", func() float64 {
+		return float64(g.Stats().Leads)
+	})
+	counter("resizer_flight_shares_total", "Number of resizing shared with in-flight one.", func() float64 {
+		return float64(g.Stats().Shares)
+	})
+
+	counter("resizer_lru_hits_total", "Number of hits of in-process cache.", func() float64 {
+		return float64(c.Stats().Hits)
+	})
+	counter("resizer_lru_misses_total", "Number of misses of in-process cache.", func() float64 {
+		return float64(c.Stats().Misses)
+	})
+	gauge("resizer_lru_entries", "Number of entries in in-process cache.", func() float64 {
+		return float64(c.Stats().Entries)
+	})
+	gauge("resizer_lru_bytes", "Bytes of entries in in-process cache.", func() float64 {
+		return float64(c.Stats().Bytes)
+	})
+
+	counter("resizer_source_cache_hits_total", "Number of hits of source image cache.", func() float64 {
+		return float64(f.CacheStats().Hits)
+	})
+	counter("resizer_source_cache_misses_total", "Number of misses of source image cache.", func() float64 {
+		return float64(f.CacheStats().Misses)
+	})
+	gauge("resizer_source_cache_entries", "Number of cached source images.", func() float64 {
+		return float64(f.CacheStats().Entries)
+	})
+	gauge("resizer_source_cache_bytes", "Bytes of cached source images.", func() float64 {
+		return float64(f.CacheStats().Bytes)
+	})
+
+	gauge("resizer_pool_workers", "Max number of images decoded and resized simultaneously.", func() float64 {
+		return float64(p.Stats().Workers)
+	})
+	gauge("resizer_pool_running", "Number of images being decoded or resized.", func() float64 {
+		return float64(p.Stats().Running)
+	})
+	gauge("resizer_pool_waiting", "Number of requests waiting for decoding or resizing.", func() float64 {
+		return float64(p.Stats().Waiting)
+	})
+	counter("resizer_pool_rejected_total", "Number of requests rejected because the queue is full.", func() float64 {
+		return float64(p.Stats().Rejected)
+	})
+	counter("resizer_pool_timed_out_total", "Number of requests timed out in the queue.", func() float64 {
+		return float64(p.Stats().TimedOut)
+	})
+	return m
+}
+
+// observe は段階 stage の開始時刻 start からの経過時間を記録する。
+func (m *serverMetrics) observe(stage string, start time.Time) {
+	m.durations.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+}
+
+// lookup はオプション options でのキャッシュの検索結果を記録する。
+func (m *serverMetrics) lookup(options string, hit bool) {
+	if hit {
+		m.lookups.WithLabelValues(options, "hit").Inc()
+	} else {
+		m.lookups.WithLabelValues(options, "miss").Inc()
+	}
+}
+
+// request はレスポンス w の結果を記録する。
+func (m *serverMetrics) request(w *responseWriter) {
+	format := w.format
+	if format == "" {
+		format = "unknown"
+	}
+	m.requests.WithLabelValues(strconv.Itoa(w.code()), format).Inc()
+	m.bytes.WithLabelValues("out").Add(float64(w.bytes))
+}
+
+// responseWriter はレスポンスのステータスコードとボディのバイト数を記録する。
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+	// format はリクエストされたフォーマット。
+	format string
+}
+
+// code はレスポンスのステータスコードを返す。
+func (w *responseWriter) code() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
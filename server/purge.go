@@ -0,0 +1,267 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minodisk/resizer/logger"
+	"github.com/minodisk/resizer/storage"
+	"github.com/minodisk/resizer/trace"
+	"github.com/pkg/errors"
+)
+
+// PathPurge はリサイズ画像を削除するパス。
+const PathPurge = "/admin/purge"
+
+// PurgeResult はリサイズ画像の削除のレスポンス。
+type PurgeResult struct {
+	DryRun bool          `json:"dry_run"`
+	Images []PurgedImage `json:"images"`
+	Error  string        `json:"error,omitempty"`
+}
+
+// PurgedImage は削除したリサイズ画像を表す。
+type PurgedImage struct {
+	ID             uint64 `json:"id"`
+	URL            string `json:"url"`
+	Filename       string `json:"filename"`
+	NormalizedHash string `json:"normalized_hash"`
+}
+
+// servePurge は元画像の URL、その接頭辞、または正規化済みのオプションのハッシュに一致する
+// リサイズ画像のレコードとオブジェクトを削除し、キャッシュを破棄する。
+// dry_run が指定された場合は削除せずに対象のリサイズ画像をレスポンスする。
+func (h *Handler) servePurge(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		resp.Header().Set("Allow", http.MethodPost)
+		writeJSON(resp, http.StatusMethodNotAllowed, PurgeResult{Error: "method must be POST"})
+		return
+	}
+	if !h.authorize(req) {
+		resp.Header().Set("WWW-Authenticate", `Bearer realm="resizer"`)
+		writeJSON(resp, http.StatusUnauthorized, PurgeResult{Error: "invalid token"})
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		writeJSON(resp, http.StatusBadRequest, PurgeResult{Error: err.Error()})
+		return
+	}
+	var dryRun bool
+	if v := req.Form.Get("dry_run"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			writeJSON(resp, http.StatusBadRequest, PurgeResult{Error: "dry_run must be boolean"})
+			return
+		}
+	}
+	u, prefix, hash := req.Form.Get("url"), req.Form.Get("prefix"), req.Form.Get("hash")
+	n := 0
+	for _, v := range []string{u, prefix, hash} {
+		if v != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		writeJSON(resp, http.StatusBadRequest, PurgeResult{Error: "one of url, prefix and hash must be specified"})
+		return
+	}
+	r := PurgeResult{DryRun: dryRun, Images: []PurgedImage{}}
+
+	ctx, span := trace.Start(ctx, "purge")
+	defer span.End()
+	if !dryRun {
+		// 記録した後に始まる保存はリサイズ画像を書き戻さず、記録する前に始まった保存は完了しているため
+		// レコードを探す前に記録する
+		m := purgeMark{url: u, prefix: prefix, hash: hash, at: time.Now()}
+		h.markPurge(m)
+		// 保存を待っている結果はレコードがないため、要求に一致するものをキャッシュから破棄する
+		h.Cache.RemoveFunc(func(key string, v interface{}) bool {
+			r, ok := v.(*result)
+			return ok && m.match(r.image)
+		})
+	}
+	images, err := h.findPurged(ctx, u, prefix, hash)
+	if err != nil {
+		span.RecordError(err)
+		r.Error = err.Error()
+		writeJSON(resp, http.StatusInternalServerError, r)
+		return
+	}
+	if !dryRun {
+		images, err = h.purge(ctx, images)
+		span.RecordError(err)
+	}
+	span.SetAttributes("dry_run", dryRun, "images", len(images))
+	for _, i := range images {
+		r.Images = append(r.Images, PurgedImage{
+			ID:             i.ID,
+			URL:            i.ValidatedURL,
+			Filename:       i.Filename,
+			NormalizedHash: i.NormalizedHash,
+		})
+	}
+	l := logger.FromContext(ctx)
+	if err != nil {
+		l.Error("fail to purge", "images", len(images), "err", err)
+		r.Error = err.Error()
+		writeJSON(resp, http.StatusInternalServerError, r)
+		return
+	}
+	l.Info("purge", "dry_run", dryRun, "images", len(images))
+	writeJSON(resp, http.StatusOK, r)
+}
+
+// authorize はリクエスト req の Authorization ヘッダーに -admin-token が指定されているかを調べる。
+func (h *Handler) authorize(req *http.Request) bool {
+	const prefix = "Bearer "
+	a := req.Header.Get("Authorization")
+	if !strings.HasPrefix(a, prefix) {
+		return false
+	}
+	token := strings.TrimSpace(a[len(prefix):])
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.Options.AdminToken)) == 1
+}
+
+// findPurged は元画像の URL u、その接頭辞 prefix、または正規化済みのオプションのハッシュ hash の
+// うち指定されたものに一致するリサイズ画像のレコードを探す。
+func (h *Handler) findPurged(ctx context.Context, u, prefix, hash string) ([]storage.Image, error) {
+	s := h.Storage.WithContext(ctx)
+	var (
+		images []storage.Image
+		err    error
+	)
+	switch {
+	case u != "":
+		images, err = s.FindByURL(u)
+	case prefix != "":
+		images, err = s.FindByURLPrefix(prefix)
+	default:
+		images, err = s.FindByNormalizedHash(hash)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to find images")
+	}
+	return images, nil
+}
+
+// purge はリサイズ画像 images のオブジェクトとレコードを削除し、キャッシュを破棄する。
+// 削除できたリサイズ画像を返す。
+// オブジェクトが残っているとレコードが再構築されうるため、オブジェクトから削除する。
+func (h *Handler) purge(ctx context.Context, images []storage.Image) ([]storage.Image, error) {
+	u := h.Uploader.WithContext(ctx)
+	s := h.Storage.WithContext(ctx)
+	// 同じオブジェクトを複数のレコードが参照している場合がある
+	deleted := make(map[string]bool)
+	for n, i := range images {
+		if !deleted[i.Filename] {
+			if err := u.Delete(i.Filename); err != nil {
+				return images[:n], err
+			}
+			deleted[i.Filename] = true
+		}
+		if err := s.Delete(i); err != nil {
+			return images[:n], errors.Wrap(err, "fail to delete record")
+		}
+		h.invalidate(i)
+		h.Flight.Forget(validatedKey(i))
+		h.Flight.Forget(normalizedKey(i))
+		h.Fetcher.RemoveCache(i.ValidatedURL)
+	}
+	return images, nil
+}
+
+// purgeMark は削除の要求を表す。
+type purgeMark struct {
+	url, prefix, hash string
+	at                time.Time
+	// seq は要求を記録した時点で、次にリサイズを始める際に割り当てる番号。
+	seq uint64
+}
+
+// match はリサイズ画像 i が要求より前にリサイズされ、要求の対象に一致するかを判定する。
+func (m purgeMark) match(i storage.Image) bool {
+	if !i.CreatedAt.Before(m.at) {
+		return false
+	}
+	switch {
+	case m.url != "":
+		return i.ValidatedURL == m.url
+	case m.prefix != "":
+		return strings.HasPrefix(i.ValidatedURL, m.prefix)
+	default:
+		return i.NormalizedHash == m.hash
+	}
+}
+
+// purges は削除の要求を記録し、要求より前にリサイズした画像の保存が
+// 削除した後にレコードとオブジェクトを書き戻さないようにする。
+// 記録は再起動すると失われるため、再開した保存には効かない。
+type purges struct {
+	// saving は保存している間は読み取りで、要求を記録する間は書き込みでロックする。
+	saving sync.RWMutex
+	mu     sync.Mutex
+	marks  []purgeMark
+	// next は次にリサイズを始める際に割り当てる番号、active は保存を終えていないリサイズの番号。
+	next   uint64
+	active map[uint64]bool
+}
+
+// begin はリサイズを始める際に番号を割り当てる。
+// 保存が完了するか諦めた時に、割り当てた番号で end を呼ぶ。
+func (p *purges) begin() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.active == nil {
+		p.active = map[uint64]bool{}
+	}
+	seq := p.next
+	p.next++
+	p.active[seq] = true
+	return seq
+}
+
+// end は番号 seq のリサイズの保存を終えたことを記録する。
+func (p *purges) end(seq uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.active, seq)
+}
+
+// match はリサイズ画像 i が記録した要求のいずれかに一致するかを判定する。
+func (p *purges) match(i storage.Image) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, m := range p.marks {
+		if m.match(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// markPurge は削除の要求 m を記録する。実行中の保存があれば、その完了を待ってから記録する。
+func (h *Handler) markPurge(m purgeMark) {
+	p := h.purges
+	p.saving.Lock()
+	defer p.saving.Unlock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// 以前の要求は、それより前に始まったリサイズの保存が全て終わっていればもう必要ない
+	// キューの長さでは、リサイズを終えてキューに積む前の画像を見落とす
+	marks := p.marks[:0]
+	for _, old := range p.marks {
+		for seq := range p.active {
+			if seq < old.seq {
+				marks = append(marks, old)
+				break
+			}
+		}
+	}
+	m.seq = p.next
+	p.marks = append(marks, m)
+}
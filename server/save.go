@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+
+	"github.com/minodisk/resizer/logger"
+	"github.com/minodisk/resizer/queue"
+	"github.com/minodisk/resizer/storage"
+	"github.com/minodisk/resizer/trace"
+)
+
+// saveJob はリサイズ画像の保存としてキューに記録する内容。
+// 再起動した後もリクエストのログとトレースに関連付けられるように、リクエストの ID とスパンを持つ。
+type saveJob struct {
+	Image       storage.Image `json:"image"`
+	Bytes       []byte        `json:"bytes"`
+	RequestID   string        `json:"request_id"`
+	Traceparent string        `json:"traceparent"`
+}
+
+// enqueue はリサイズ画像 f とそのデータ b の保存をキューに積む。
+// 保存が完了するか諦めた時、またはキューに積めなかった時に done を呼ぶ。
+func (h *Handler) enqueue(ctx context.Context, b []byte, f storage.Image, done func()) {
+	j := saveJob{
+		Image:     f,
+		Bytes:     b,
+		RequestID: requestIDFromContext(ctx),
+	}
+	if sc := trace.FromContext(ctx).SpanContext(); sc.IsValid() {
+		j.Traceparent = sc.Traceparent()
+	}
+	if err := h.Saves.Push(j, func(error) { done() }); err != nil {
+		logger.FromContext(ctx).Error("fail to queue save", "filename", f.Filename, "err", err)
+		done()
+	}
+}
+
+// runSave はキューに記録された保存 j を実行する。
+// エラーを返すと Options.SaveRetryInterval から倍ずつ間隔を空けて再試行される。
+func (h *Handler) runSave(ctx context.Context, j queue.Job) error {
+	s := saveJob{}
+	if err := j.Decode(&s); err != nil {
+		return err
+	}
+	parent, _ := trace.ParseTraceparent(s.Traceparent)
+	ctx, span := h.Tracer.StartRoot(ctx, "save", parent)
+	span.SetAttributes("job", j.ID, "attempt", j.Attempts+1)
+	defer span.End()
+	l := logger.Default().With("request_id", s.RequestID, "job", j.ID)
+	if sc := span.SpanContext(); sc.IsValid() {
+		l = l.With("trace_id", sc.TraceID.String())
+	}
+	ctx = logger.NewContext(ctx, l)
+
+	err := h.save(ctx, s.Bytes, s.Image)
+	span.RecordError(err)
+	if err == nil {
+		return nil
+	}
+	if h.Options.SaveRetries >= 0 && j.Attempts >= h.Options.SaveRetries {
+		l.Error("give up saving", "filename", s.Image.Filename, "attempts", j.Attempts+1, "err", err)
+	} else {
+		l.Warn("fail to save", "filename", s.Image.Filename, "attempts", j.Attempts+1, "err", err)
+	}
+	return err
+}
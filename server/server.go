@@ -1,24 +1,37 @@
 package server
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"crypto/md5"
 	"fmt"
+	"image"
 	"io"
-	"log"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/alecthomas/template"
+	"github.com/minodisk/resizer/cache"
 	"github.com/minodisk/resizer/fetcher"
+	"github.com/minodisk/resizer/flight"
 	"github.com/minodisk/resizer/input"
+	"github.com/minodisk/resizer/logger"
 	"github.com/minodisk/resizer/options"
+	"github.com/minodisk/resizer/pool"
 	"github.com/minodisk/resizer/processor"
+	"github.com/minodisk/resizer/queue"
+	"github.com/minodisk/resizer/signature"
 	"github.com/minodisk/resizer/storage"
+	"github.com/minodisk/resizer/trace"
 	"github.com/minodisk/resizer/uploader"
+	"github.com/minodisk/resizer/version"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"golang.org/x/net/netutil"
 )
 
@@ -72,12 +85,15 @@ func init() {
 	}
 }
 
+// Start はサーバーを起動する。
+// SIGTERM か SIGINT を受け取ると新しい接続の受け付けを止め、処理中のリクエストとリサイズ画像の保存を
+// -shutdown-timeout まで待ってから終了する。
 func Start(o *options.Options) error {
 	handler, err := NewHandler(o)
 	if err != nil {
 		return err
 	}
-	server := http.Server{
+	server := &http.Server{
 		Handler:        &handler,
 		ReadTimeout:    10 * time.Second,
 		MaxHeaderBytes: 1 << 20,
@@ -87,14 +103,42 @@ func Start(o *options.Options) error {
 		return err
 	}
 
-	log.Printf("listening on port %d", o.Port)
+	v := version.Get()
+	logger.Default().Info("listening", "port", o.Port, "version", v.Version, "commit", v.Commit)
+
+	var metricsServer *http.Server
+	if o.MetricsPort > 0 {
+		metricsServer, err = handler.serveMetrics(o.MetricsPort)
+		if err != nil {
+			return err
+		}
+	}
 
 	if o.MaxHTTPConnections > 0 {
 		listener = netutil.LimitListener(listener, o.MaxHTTPConnections)
 	}
-	if err := server.Serve(listener); err != nil {
+
+	// 前回の終了時に完了しなかった保存を再開する
+	handler.Saves.Resume()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(sig)
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- server.Serve(listener)
+	}()
+	select {
+	case err := <-errc:
 		return errors.Wrap(err, "fail to serve")
+	case s := <-sig:
+		logger.Default().Info("shutting down", "signal", s.String(), "timeout", o.ShutdownTimeout)
 	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.ShutdownTimeout)
+	defer cancel()
+	handler.shutdown(ctx, server, metricsServer)
 	return nil
 }
 
@@ -102,6 +146,14 @@ type Handler struct {
 	Options  *options.Options
 	Storage  *storage.Storage
 	Uploader *uploader.Uploader
+	Flight   *flight.Group
+	Cache    *cache.LRU
+	Fetcher  *fetcher.Fetcher
+	Pool     *pool.Pool
+	Tracer   *trace.Tracer
+	Saves    *queue.Queue
+	metrics  *serverMetrics
+	purges   *purges
 }
 
 func NewHandler(o *options.Options) (Handler, error) {
@@ -113,147 +165,437 @@ func NewHandler(o *options.Options) (Handler, error) {
 	if err != nil {
 		return Handler{}, err
 	}
-	return Handler{
+	f, err := fetcher.New(o)
+	if err != nil {
+		return Handler{}, err
+	}
+	h := Handler{
 		Options:  o,
 		Storage:  s,
 		Uploader: u,
-	}, nil
+		Flight:   &flight.Group{},
+		Cache:    cache.New(o.CacheEntries, o.CacheSize),
+		Fetcher:  f,
+		Pool:     pool.New(o.Workers, o.QueueSize, o.QueueTimeout),
+		Tracer:   newTracer(o),
+		purges:   &purges{},
+	}
+	h.Saves, err = queue.Open(o.SaveQueueDir, o.SaveRetries, o.SaveRetryInterval, h.runSave)
+	if err != nil {
+		return Handler{}, err
+	}
+	h.metrics = newMetrics(h.Cache, h.Flight, h.Fetcher, h.Pool, h.Saves)
+	return h, nil
 }
 
-// ServeHTTP はリクエストに応じて処理を行いレスポンスする。
-func (h *Handler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
-	if err := h.operate(resp, req); err != nil {
-		log.Println(errors.Wrap(err, "fail to operate"))
-		resp.WriteHeader(http.StatusBadRequest)
+// serveMetrics はポート port でメトリクスを公開する。
+func (h *Handler) serveMetrics(port int) (*http.Server, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to listen for metrics")
+	}
+	logger.Default().Info("serving metrics", "port", port)
 
-		e := NewErrorHTML(http.StatusBadRequest, errors.Cause(err).Error())
-		err := errorHTMLTemplate.Execute(resp, e)
-		if err != nil {
-			log.Println(errors.Wrap(err, "fail to generate error html from template"))
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(h.metrics.registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Default().Error("fail to serve metrics", "err", err)
 		}
+	}()
+	return server, nil
+}
+
+// ServeHTTP はリクエストに応じて処理を行いレスポンスする。
+// リクエストごとに ID を割り当て、処理のログとアクセスログに付加する。
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if h.serveProbe(w, req) {
+		return
+	}
+
+	start := time.Now()
+	id := requestID(req)
+	w.Header().Set(headerRequestID, id)
+	// リサイズ画像の保存はレスポンスの後も続くため、リクエストの Context からは派生させない
+	parent, _ := trace.ParseTraceparent(req.Header.Get(headerTraceparent))
+	ctx, span := h.Tracer.StartRoot(context.Background(), "request", parent)
+	span.SetAttributes("http.method", req.Method, "http.target", req.URL.RequestURI(), "request_id", id)
+	l := logger.Default().With("request_id", id)
+	if sc := span.SpanContext(); sc.IsValid() {
+		l = l.With("trace_id", sc.TraceID.String())
+	}
+	ctx = logger.NewContext(ctx, l)
+	ctx = withRequestID(ctx, id)
+
+	resp := &responseWriter{ResponseWriter: w}
+	defer func() {
+		h.metrics.request(resp)
+		accessLog(l, req, resp, time.Since(start))
+		span.SetAttributes("http.status_code", resp.code())
+		span.End()
+	}()
 
+	if req.URL.Path == PathPurge && h.Options.AdminToken != "" {
+		h.servePurge(ctx, resp, req)
 		return
 	}
 
-	log.Println("OK")
+	if err := h.operate(ctx, resp, req); err != nil {
+		span.RecordError(err)
+		code := statusCode(err)
+		if code >= http.StatusInternalServerError {
+			l.Error("fail to operate", "err", err)
+		} else {
+			l.Info("fail to operate", "err", err)
+		}
+		if code == http.StatusServiceUnavailable {
+			resp.Header().Set("Retry-After", retryAfter(h.Pool.Timeout()))
+		}
+		resp.WriteHeader(code)
+
+		e := NewErrorHTML(code, errors.Cause(err).Error())
+		err := errorHTMLTemplate.Execute(resp, e)
+		if err != nil {
+			l.Error("fail to generate error html from template", "err", err)
+		}
+	}
 }
 
 // operate は手続き的に一連のリサイズ処理を行う。
 // エラーを画一的に扱うためにメソッドとして切り分けを行っている
-func (h *Handler) operate(resp http.ResponseWriter, req *http.Request) error {
-	// 1. URLクエリからリクエストされているオプションを抽出する
-	input, err := input.New(req.URL.Query())
-	if err != nil {
-		return err
-	}
-	input, err = input.Validate(h.Options.AllowedHosts)
-	if err != nil {
-		return err
-	}
-	i, err := storage.NewImage(input)
+func (h *Handler) operate(ctx context.Context, resp *responseWriter, req *http.Request) error {
+	l := logger.FromContext(ctx)
+
+	// 0〜2. リクエストを検証する
+	_, span := trace.Start(ctx, "validate")
+	i, err := h.validate(req)
+	span.RecordError(err)
+	span.End()
 	if err != nil {
 		return err
 	}
+	resp.format = i.ValidatedFormat
 
 	// 3. バリデート済みオプションでリサイズをしたキャッシュがあるか調べる
 	// 4. キャッシュがあればリサイズ画像のURLにリダイレクトする
-	cache := storage.Image{}
-	h.Storage.Where(&storage.Image{
-		ValidatedHash:    i.ValidatedHash,
-		ValidatedWidth:   i.ValidatedWidth,
-		ValidatedHeight:  i.ValidatedHeight,
-		ValidatedMethod:  i.ValidatedMethod,
-		ValidatedFormat:  i.ValidatedFormat,
-		ValidatedQuality: i.ValidatedQuality,
-	}).First(&cache)
-	log.Printf("cache.ID=%d\n", cache.ID)
-	if cache.ID != 0 {
-		log.Printf("validated cache %+v exists, requested with %+v\n", cache, i)
-		url := h.Uploader.CreateURL(cache.Filename)
-		http.Redirect(resp, req, url, http.StatusFound)
+	if cache, ok := h.findValidated(ctx, i); ok {
+		l.Debug("validated cache exists", "hash", i.ValidatedHash, "filename", cache.Filename)
+		h.respondCache(resp, req, cache)
 		return nil
 	}
-	log.Printf("validated cache doesn't exist, requested with %+v\n", i)
+	l.Debug("validated cache doesn't exist", "hash", i.ValidatedHash, "url", i.ValidatedURL)
+
+	// 保存が完了していないリサイズ画像はメモリから返す
+	r, ok := h.findPending(pendingValidatedKey(i))
+	if ok {
+		l.Debug("pending result exists", "hash", i.ValidatedHash)
+	} else {
+		// 5〜11 は同一のオプションのリクエストが同時に届いた場合に一度だけ処理し、結果を共有する
+		key := validatedKey(i)
+		v, shared, err := h.Flight.Do(key, func() (interface{}, error) {
+			return h.process(ctx, i)
+		})
+		if err != nil {
+			return err
+		}
+		r = v.(*result)
+		if shared {
+			l.Debug("coalesced with in-flight resizing", "key", key)
+		} else {
+			// レスポンスした後のリクエストには、保存したキャッシュか保存を待つ結果を返す
+			defer h.Flight.Forget(key)
+		}
+	}
+	if r.cached {
+		h.respondCache(resp, req, r.image)
+		return nil
+	}
+
+	// 12. レスポンスする
+	resp.Header().Set("Content-Type", r.image.ContentType)
+	resp.Header().Set("Content-Length", strconv.Itoa(len(r.bytes)))
+	setCacheHeaders(resp.Header(), r.image, h.Options.CacheMaxAge)
+	if notModified(req, r.image.ETag, r.image.CreatedAt) {
+		resp.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	io.Copy(resp, bytes.NewReader(r.bytes))
+
+	return nil
+}
+
+// validate はリクエスト req の署名とオプションを検証し、バリデート済みのオプションを返す。
+func (h *Handler) validate(req *http.Request) (storage.Image, error) {
+	// 0. 署名の鍵が指定されていればURLの署名を検証する
+	if len(h.Options.SigningKeys) > 0 {
+		if err := signature.Verify(h.Options.SigningKeys.Bytes(), req.URL.EscapedPath(), req.URL.Query(), time.Now()); err != nil {
+			return storage.Image{}, err
+		}
+	}
+
+	// 1. URLのパスとクエリからリクエストされているオプションを抽出する
+	// 2. オプションをバリデートする
+	q, err := input.ParsePath(req.URL.EscapedPath(), req.URL.Query())
+	if err != nil {
+		return storage.Image{}, err
+	}
+	input, err := input.New(q, h.Options)
+	if err != nil {
+		return storage.Image{}, err
+	}
+	input, err = input.Validate(h.Options.AllowedHosts)
+	if err != nil {
+		return storage.Image{}, err
+	}
+	return storage.NewImage(input)
+}
+
+// result はリサイズ処理の結果を表す。
+// 同時に届いた同一のリクエストの間で共有されるため、生成後に変更してはならない。
+type result struct {
+	image  storage.Image
+	bytes  []byte
+	cached bool
+	// seq は削除の要求と前後を比べるために、リサイズを始めた際に割り当てた番号。
+	seq uint64
+}
+
+// process は元画像を取得してリサイズを行い、その結果を返す。
+// リサイズ画像の保存は非同期に行われる。
+func (h *Handler) process(ctx context.Context, i storage.Image) (*result, error) {
+	l := logger.FromContext(ctx)
 
 	// 5. 元画像を取得する
 	// 6. リサイズの前処理をする
-	filename, err := fetcher.Fetch(i.ValidatedURL)
-	fmt.Printf("URL: %s, Filename: %s\n", i.ValidatedURL, filename)
+	start := time.Now()
+	fctx, span := trace.Start(ctx, "fetch")
+	span.SetAttributes("url", i.ValidatedURL)
+	filename, err := h.Fetcher.WithContext(fctx).WithOrigin(i.Origin).Fetch(i.ValidatedURL)
+	span.RecordError(err)
+	span.End()
+	if err != nil {
+		return nil, err
+	}
 	defer func() {
-		if err := fetcher.Clean(filename); err != nil {
-			log.Printf("fail to clean fetched file: %s\n", filename)
+		if err := h.Fetcher.Clean(filename); err != nil {
+			l.Warn("fail to clean fetched file", "filename", filename, "err", err)
 		}
 	}()
-	if err != nil {
-		return err
+	h.metrics.observe(stageFetch, start)
+	if fi, err := os.Stat(filename); err == nil {
+		h.metrics.bytes.WithLabelValues("in").Add(float64(fi.Size()))
 	}
-	var b []byte
-	buf := bytes.NewBuffer(b)
-	p := processor.New()
-	pixels, err := p.Preprocess(filename)
+
+	// デコードとリサイズは CPU の負荷が高いため、同時に実行する数を制限する
+	p := processor.New(h.Options).WithContext(ctx)
+	var pixels image.Image
+	_, span = trace.Start(ctx, "preprocess")
+	err = h.Pool.Do(func() error {
+		start := time.Now()
+		var err error
+		pixels, err = p.Preprocess(filename)
+		if err != nil {
+			return err
+		}
+		h.metrics.observe(stageDecode, start)
+		return nil
+	})
+	span.RecordError(err)
+	span.End()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// 7. 正規化する
 	// 8. 正規化済みのオプションでリサイズをしたことがあるか調べる
 	// 9. あればリサイズ画像のURLにリダイレクトする
+	_, span = trace.Start(ctx, "normalize")
 	i, err = i.Normalize(pixels.Bounds().Size())
+	span.RecordError(err)
+	span.End()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	cache = storage.Image{}
-	h.Storage.Where(&storage.Image{
-		NormalizedHash:   i.NormalizedHash,
-		DestWidth:        i.DestWidth,
-		DestHeight:       i.DestHeight,
-		ValidatedMethod:  i.ValidatedMethod,
-		ValidatedFormat:  i.ValidatedFormat,
-		ValidatedQuality: i.ValidatedQuality,
-	}).First(&cache)
-	if cache.ID != 0 {
-		log.Printf("normalized cache %+v exists, requested with %+v\n", cache, i)
-		url := h.Uploader.CreateURL(cache.Filename)
-		http.Redirect(resp, req, url, http.StatusFound)
-		return nil
+	if cache, ok := h.findNormalized(ctx, i); ok {
+		l.Debug("normalized cache exists", "hash", i.NormalizedHash, "filename", cache.Filename)
+		return &result{image: cache, cached: true}, nil
+	}
+	l.Debug("normalized cache doesn't exist", "hash", i.NormalizedHash)
+	if r, ok := h.findPending(pendingNormalizedKey(i)); ok {
+		l.Debug("pending result exists", "hash", i.NormalizedHash)
+		return r, nil
+	}
+
+	// オブジェクト名が決定的な場合はオブジェクトが既に存在するか調べ、
+	// 存在すればDBのレコードを再構築してリダイレクトする
+	if h.Options.Naming == options.NamingHash {
+		cache, ok, err := h.rebuild(ctx, i)
+		if err != nil {
+			l.Warn("fail to rebuild cache", "err", err)
+		} else if ok {
+			return &result{image: cache, cached: true}, nil
+		}
 	}
-	log.Printf("normalized cache doesn't exist, requested with %+v\n", i)
 
 	// 10. リサイズする
 	// 11. ファイルオブジェクトの処理結果フィールドを埋める
-	// 12. レスポンスする
-	size, err := p.Resize(pixels, buf, i)
+	// 正規化済みのオプションが同一のリクエストが同時に届いた場合も一度だけ処理する
+	key := normalizedKey(i)
+	v, shared, err := h.Flight.Do(key, func() (interface{}, error) {
+		// 保存を終えるまでは、この後に記録される削除の要求を残させる
+		seq := h.purges.begin()
+		buf := new(bytes.Buffer)
+		var resized image.Image
+		_, span := trace.Start(ctx, "resize")
+		span.SetAttributes("method", i.ValidatedMethod, "format", i.ValidatedFormat,
+			"width", i.DestWidth, "height", i.DestHeight)
+		defer span.End()
+		if err := h.Pool.Do(func() error {
+			start := time.Now()
+			var err error
+			resized, err = p.Transform(pixels, i)
+			if err != nil {
+				return err
+			}
+			h.metrics.observe(stageResize, start)
+
+			start = time.Now()
+			if err := p.Encode(buf, resized, i); err != nil {
+				return err
+			}
+			h.metrics.observe(stageEncode, start)
+			return nil
+		}); err != nil {
+			span.RecordError(err)
+			h.purges.end(seq)
+			return nil, err
+		}
+		size := resized.Bounds().Size()
+		b := buf.Bytes()
+
+		i.ETag = fmt.Sprintf("%x", md5.Sum(b))
+		i.Filename = i.CreateFilename(h.Options)
+		i.ContentType = contentTypes[i.ValidatedFormat]
+		i.CanvasWidth = size.X
+		i.CanvasHeight = size.Y
+		i.CreatedAt = time.Now()
+
+		return &result{image: i, bytes: b, seq: seq}, nil
+	})
 	if err != nil {
-		return err
+		return nil, err
+	}
+	r := v.(*result)
+	if shared {
+		l.Debug("coalesced with in-flight resizing", "key", key)
+		return r, nil
 	}
-	b = buf.Bytes()
 
-	i.ETag = fmt.Sprintf("%x", md5.Sum(b))
-	i.Filename = i.CreateFilename(h.Options)
-	i.ContentType = contentTypes[i.ValidatedFormat]
-	i.CanvasWidth = size.X
-	i.CanvasHeight = size.Y
+	defer h.Flight.Forget(key)
+	// 書き込み時に保存する場合は、保存を完了させてからレスポンスする
+	if h.Options.WriteThrough {
+		err := h.saveThrough(ctx, r.bytes, r.image)
+		if err == nil {
+			h.purges.end(r.seq)
+			return r, nil
+		}
+		l.Warn("fail to save before responding", "filename", r.image.Filename, "err", err)
+	}
+	// レスポンスを完了させるためにキューに積んで非同期に保存する
+	// 保存が完了するか諦めるまでは、後続のリクエストにメモリにキャッシュした結果を返す
+	h.addPending(r)
+	h.enqueue(ctx, r.bytes, r.image, func() {
+		h.removePending(r.image)
+		h.purges.end(r.seq)
+	})
 
-	resp.Header().Add("Content-Type", i.ContentType)
-	io.Copy(resp, bufio.NewReader(buf))
+	return r, nil
+}
 
-	// レスポンスを完了させるために非同期に処理する
-	go h.save(b, i)
+// saveThrough はレスポンスする前にリサイズ画像 f とそのデータ b を保存する。
+// リクエストが保存で長く待たされないように、Options.WriteThroughTimeout で打ち切る。
+func (h *Handler) saveThrough(ctx context.Context, b []byte, f storage.Image) error {
+	if h.Options.WriteThroughTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Options.WriteThroughTimeout)
+		defer cancel()
+	}
+	return h.save(ctx, b, f)
+}
 
-	return nil
+// respondCache はキャッシュ済みのリサイズ画像についてレスポンスする。
+// 条件付きリクエストの条件を満たす場合は 304 Not Modified を、
+// リサイズ画像のデータがメモリにキャッシュされていればそのデータを、
+// そうでなければリサイズ画像のURLへのリダイレクトをレスポンスする。
+func (h *Handler) respondCache(resp http.ResponseWriter, req *http.Request, cache storage.Image) {
+	if notModified(req, cache.ETag, cache.CreatedAt) {
+		setCacheHeaders(resp.Header(), cache, h.Options.CacheMaxAge)
+		resp.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if v, ok := h.Cache.Get(bytesKey(cache)); ok {
+		b := v.([]byte)
+		resp.Header().Set("Content-Type", cache.ContentType)
+		resp.Header().Set("Content-Length", strconv.Itoa(len(b)))
+		setCacheHeaders(resp.Header(), cache, h.Options.CacheMaxAge)
+		resp.Write(b)
+		return
+	}
+	url := h.Uploader.CreateURL(cache.Filename)
+	http.Redirect(resp, req, url, http.StatusFound)
+}
+
+// rebuild は正規化済みのオプションから決定されるオブジェクトが存在する場合に、
+// オブジェクトの属性からDBのレコードを再構築する。
+func (h *Handler) rebuild(ctx context.Context, i storage.Image) (storage.Image, bool, error) {
+	i.Filename = i.CreateFilename(h.Options)
+	attrs, ok, err := h.Uploader.WithContext(ctx).Exists(i.Filename)
+	if err != nil || !ok {
+		return i, false, err
+	}
+	i.ETag = fmt.Sprintf("%x", attrs.MD5)
+	i.ContentType = attrs.ContentType
+	if err := h.Storage.WithContext(ctx).Create(&i).Error; err != nil {
+		return i, false, err
+	}
+	logger.FromContext(ctx).Debug("rebuild cache from object", "filename", i.Filename)
+	h.addCache(i, nil)
+	return i, true, nil
 }
 
 // save はファイルやデータを保存します。
-func (h *Handler) save(b []byte, f storage.Image) {
+func (h *Handler) save(ctx context.Context, b []byte, f storage.Image) error {
+	l := logger.FromContext(ctx)
+	// 保存している間は削除を待たせ、保存の前に削除された画像は保存しない
+	h.purges.saving.RLock()
+	defer h.purges.saving.RUnlock()
+	if h.purges.match(f) {
+		l.Info("skip saving purged image", "filename", f.Filename)
+		return nil
+	}
 	// 13. アップロードする
 	// 14. キャッシュをDBに格納する
-	if _, err := h.Uploader.Upload(bytes.NewBuffer(b), f); err != nil {
-		log.Println(errors.Wrap(err, "fail to upload"))
-		return
+	start := time.Now()
+	uctx, span := trace.Start(ctx, "upload")
+	span.SetAttributes("filename", f.Filename, "bytes", len(b))
+	_, err := h.Uploader.WithContext(uctx).Upload(bytes.NewBuffer(b), f)
+	span.RecordError(err)
+	span.End()
+	if err != nil {
+		return errors.Wrap(err, "fail to upload")
 	}
-	h.Storage.NewRecord(f)
-	h.Storage.Create(&f)
-	h.Storage.Save(&f)
+	h.metrics.observe(stageUpload, start)
 
-	log.Println("complete to save")
+	sctx, span := trace.Start(ctx, "insert")
+	err = h.Storage.WithContext(sctx).Create(&f).Error
+	span.RecordError(err)
+	span.End()
+	if err != nil {
+		return errors.Wrap(err, "fail to insert")
+	}
+	h.addCache(f, b)
+
+	l.Debug("complete to save", "filename", f.Filename)
+	return nil
 }
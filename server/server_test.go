@@ -1,6 +1,9 @@
 package server_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -10,11 +13,14 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/minodisk/resizer/options"
 	"github.com/minodisk/resizer/server"
+	"github.com/minodisk/resizer/storage"
 	"github.com/minodisk/resizer/testutil"
 	"github.com/pkg/errors"
 )
@@ -35,18 +41,7 @@ func TestMain(m *testing.M) {
 	fixturesServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, filepath.Join(testutil.DirFixtures, r.URL.Path[1:]))
 	}))
-	u, err := url.Parse(fixturesServer.URL)
-	if err != nil {
-		panic(err)
-	}
-
-	h, err := server.NewHandler(&options.Options{
-		ServiceAccount: options.ServiceAccount{
-			Path: testutil.GoogleAuthFilename,
-		},
-		DataSourceName: "root:@tcp(mysql:3306)/resizer?charset=utf8&parseTime=True",
-		AllowedHosts:   []string{u.Host},
-	})
+	h, err := server.NewHandler(newOptions())
 	if err != nil {
 		panic(err)
 	}
@@ -67,6 +62,27 @@ func TestMain(m *testing.M) {
 	os.Exit(c)
 }
 
+// newOptions は fixturesServer から元画像を取得するサーバーのオプションを返す。
+func newOptions() *options.Options {
+	u, err := url.Parse(fixturesServer.URL)
+	if err != nil {
+		panic(err)
+	}
+	var loopback options.Networks
+	if err := loopback.Set("127.0.0.0/8,::1"); err != nil {
+		panic(err)
+	}
+	return &options.Options{
+		ServiceAccount: options.ServiceAccount{
+			Path: testutil.GoogleAuthFilename,
+		},
+		DataSourceName:  "root:@tcp(mysql:3306)/resizer?charset=utf8&parseTime=True",
+		AllowedHosts:    options.MustHosts(u.Host),
+		AllowedNetworks: loopback,
+		AdminToken:      "t0k3n",
+	}
+}
+
 func TestNew(t *testing.T) {
 	t.Run("1st time", func(t *testing.T) {
 		client := &http.Client{
@@ -128,6 +144,65 @@ func TestNew(t *testing.T) {
 	}()
 }
 
+func TestNotModified(t *testing.T) {
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			t.Fatalf("shouldn't be redirected")
+			return nil
+		},
+	}
+	u := fmt.Sprintf("%s?width=17&url=%s/f-png24.png", appServer.URL, fixturesServer.URL)
+	resp, err := client.Get(u)
+	if err != nil {
+		t.Fatalf("fail to get resized image: %+v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status code isn't OK: %d", resp.StatusCode)
+	}
+	for _, k := range []string{"ETag", "Last-Modified", "Cache-Control", "Content-Length"} {
+		if resp.Header.Get(k) == "" {
+			t.Errorf("%s header should be set", k)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-None-Match", resp.Header.Get("ETag"))
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("fail to get resized image with If-None-Match: %+v", err)
+	}
+	resp.Body.Close()
+	if a, e := resp.StatusCode, http.StatusNotModified; a != e {
+		t.Errorf("status code is expected `%d`, but actual `%d`", e, a)
+	}
+}
+
+func TestPath(t *testing.T) {
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			t.Fatalf("shouldn't be redirected")
+			return nil
+		},
+	}
+	src := url.PathEscape(fmt.Sprintf("%s/f-png24.png", fixturesServer.URL))
+	resp, err := client.Get(fmt.Sprintf("%s/w_19,f_png/%s", appServer.URL, src))
+	if err != nil {
+		t.Fatalf("fail to get resized image: %+v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		t.Fatalf("status code isn't OK: %s", b)
+	}
+	if a, e := resp.Header.Get("Content-Type"), "image/png"; a != e {
+		t.Errorf("Content-Type is expected `%s`, but actual `%s`", e, a)
+	}
+}
+
 var (
 	rTitle   = regexp.MustCompile(`<title>(\d+ .+)<\/title>`)
 	rH1      = regexp.MustCompile(`<h1>(.+)<\/h1>`)
@@ -166,3 +241,354 @@ func TestFail(t *testing.T) {
 		t.Errorf("the application name in <address> is expected `%s`, but actual `%s`", e, a)
 	}
 }
+
+func TestRequestID(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"inherit", "abc-123", "abc-123"},
+		{"invalid", "abc 123", ""},
+		{"generate", "", ""},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", appServer.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.header != "" {
+				req.Header.Set("X-Request-Id", c.header)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			got := resp.Header.Get("X-Request-Id")
+			if c.want != "" && got != c.want {
+				t.Errorf("got: %s, want: %s", got, c.want)
+			}
+			if c.want == "" && (got == "" || got == c.header) {
+				t.Errorf("new request ID should be generated, but got '%s'", got)
+			}
+		})
+	}
+}
+
+func TestProbes(t *testing.T) {
+	for _, c := range []struct {
+		path        string
+		contentType string
+		body        string
+	}{
+		{"/healthz", "text/plain; charset=utf-8", "ok\n"},
+		{"/version", "application/json; charset=utf-8", `"version":"dev"`},
+	} {
+		t.Run(c.path, func(t *testing.T) {
+			resp, err := http.Get(appServer.URL + c.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("status code should be 200, but got %d", resp.StatusCode)
+			}
+			if got := resp.Header.Get("Content-Type"); got != c.contentType {
+				t.Errorf("Content-Type should be %s, but got %s", c.contentType, got)
+			}
+			b, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(b), c.body) {
+				t.Errorf("body should contain %s, but got %s", c.body, b)
+			}
+		})
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	var fetches int32
+	release := make(chan struct{})
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 同時に届いたリクエストが揃うまで、最初の取得へのレスポンスを待たせる
+		if atomic.AddInt32(&fetches, 1) == 1 {
+			<-release
+		}
+		http.ServeFile(w, r, filepath.Join(testutil.DirFixtures, "f-png24.png"))
+	}))
+	defer source.Close()
+	su, err := url.Parse(source.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "resizer-save-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	o := newOptions()
+	o.AllowedHosts = options.MustHosts(su.Host)
+	o.SaveQueueDir = dir
+	// リサイズした回数をレコードの数で確かめられるように、レスポンスする前に保存する
+	o.WriteThrough = true
+	h, err := server.NewHandler(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(http.HandlerFunc(h.ServeHTTP))
+	defer s.Close()
+	defer h.Saves.Close(context.Background())
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return errors.New("shouldn't be redirected")
+		},
+	}
+	u := fmt.Sprintf("%s/f-png24.png?t=%d", source.URL, time.Now().UnixNano())
+	const n = 8
+	bodies := make([][]byte, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := client.Get(fmt.Sprintf("%s?width=23&url=%s", s.URL, url.QueryEscape(u)))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("status code should be 200, but got %d", resp.StatusCode)
+				return
+			}
+			if bodies[i], err = ioutil.ReadAll(resp.Body); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	for start := time.Now(); atomic.LoadInt32(&fetches) == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 10*time.Second {
+			close(release)
+			t.Fatal("source image isn't fetched")
+		}
+	}
+	time.Sleep(500 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&fetches); got != 1 {
+		t.Errorf("source image should be fetched once, but fetched %d times", got)
+	}
+	for i, b := range bodies {
+		if len(b) == 0 || !bytes.Equal(b, bodies[0]) {
+			t.Errorf("response %d should be the same as the first one", i)
+		}
+	}
+	var images []storage.Image
+	if err := h.Storage.Where("validated_url = ?", u).Find(&images).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 1 {
+		t.Errorf("source image should be resized once, but %d records are stored", len(images))
+	}
+}
+
+func TestWriteThrough(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		timeout time.Duration
+		saved   bool
+	}{
+		{"save before responding", 0, true},
+		// 保存が打ち切られた場合はキューに積んで保存する
+		{"fallback to queue", time.Nanosecond, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "resizer-save-queue")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			o := newOptions()
+			o.SaveQueueDir = dir
+			o.WriteThrough = true
+			o.WriteThroughTimeout = c.timeout
+			h, err := server.NewHandler(o)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := httptest.NewServer(http.HandlerFunc(h.ServeHTTP))
+			defer s.Close()
+			defer h.Saves.Close(context.Background())
+
+			// 以前のテストで保存したリサイズ画像と重ならないように、元画像の URL を毎回変える
+			u := fmt.Sprintf("%s/f-png24.png?t=%d", fixturesServer.URL, time.Now().UnixNano())
+			resp, err := http.Get(fmt.Sprintf("%s?width=19&url=%s", s.URL, url.QueryEscape(u)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status code should be 200, but got %d", resp.StatusCode)
+			}
+
+			var images []storage.Image
+			for start := time.Now(); ; time.Sleep(100 * time.Millisecond) {
+				if err := h.Storage.Where("validated_url = ?", u).Find(&images).Error; err != nil {
+					t.Fatal(err)
+				}
+				if len(images) != 0 || c.saved || time.Since(start) > 10*time.Second {
+					break
+				}
+			}
+			if len(images) != 1 {
+				t.Fatalf("1 record should be stored, but got %d", len(images))
+			}
+			if _, ok, err := h.Uploader.Exists(images[0].Filename); err != nil || !ok {
+				t.Errorf("object %s should be uploaded: error=%v", images[0].Filename, err)
+			}
+		})
+	}
+}
+
+func TestPurge(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		method string
+		token  string
+		form   url.Values
+		code   int
+		body   string
+	}{
+		{"method", "GET", "t0k3n", url.Values{"hash": {"abc"}}, http.StatusMethodNotAllowed, `"error":"method must be POST"`},
+		{"no token", "POST", "", url.Values{"hash": {"abc"}}, http.StatusUnauthorized, `"error":"invalid token"`},
+		{"invalid token", "POST", "wrong", url.Values{"hash": {"abc"}}, http.StatusUnauthorized, `"error":"invalid token"`},
+		{"no target", "POST", "t0k3n", url.Values{}, http.StatusBadRequest, `"error":"one of url, prefix and hash must be specified"`},
+		{"multiple targets", "POST", "t0k3n", url.Values{"url": {"http://a.com/a.jpg"}, "hash": {"abc"}}, http.StatusBadRequest, `"error":"one of url, prefix and hash must be specified"`},
+		{"dry run", "POST", "t0k3n", url.Values{"hash": {"not-exist"}, "dry_run": {"true"}}, http.StatusOK, `{"dry_run":true,"images":[]}`},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := http.NewRequest(c.method, appServer.URL+server.PathPurge, strings.NewReader(c.form.Encode()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if c.token != "" {
+				req.Header.Set("Authorization", "Bearer "+c.token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != c.code {
+				t.Errorf("status code should be %d, but got %d", c.code, resp.StatusCode)
+			}
+			b, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(b), c.body) {
+				t.Errorf("body should contain %s, but got %s", c.body, b)
+			}
+		})
+	}
+}
+
+// requestPurge はフォーム form でリサイズ画像の削除を要求する。
+func requestPurge(t *testing.T, form url.Values) server.PurgeResult {
+	req, err := http.NewRequest("POST", appServer.URL+server.PathPurge, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer t0k3n")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	r := server.PurgeResult{}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status code should be 200, but got %d: %s", resp.StatusCode, r.Error)
+	}
+	return r
+}
+
+// waitSaved は元画像の URL u のリサイズ画像が保存されるまで待ち、そのリサイズ画像を返す。
+func waitSaved(t *testing.T, u string) server.PurgedImage {
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(100 * time.Millisecond) {
+		if r := requestPurge(t, url.Values{"url": {u}, "dry_run": {"true"}}); len(r.Images) != 0 {
+			return r.Images[0]
+		}
+	}
+	t.Fatalf("resized image of %s isn't saved", u)
+	return server.PurgedImage{}
+}
+
+func TestPurgeImages(t *testing.T) {
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return errors.New("shouldn't be redirected")
+		},
+	}
+	resize := func(t *testing.T, u string) {
+		resp, err := client.Get(fmt.Sprintf("%s?width=13&url=%s", appServer.URL, url.QueryEscape(u)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status code should be 200, but got %d", resp.StatusCode)
+		}
+	}
+
+	for _, c := range []struct {
+		name string
+		form func(prefix, u string, i server.PurgedImage) url.Values
+	}{
+		{"url", func(prefix, u string, i server.PurgedImage) url.Values {
+			return url.Values{"url": {u}}
+		}},
+		{"prefix", func(prefix, u string, i server.PurgedImage) url.Values {
+			return url.Values{"prefix": {prefix}}
+		}},
+		{"hash", func(prefix, u string, i server.PurgedImage) url.Values {
+			return url.Values{"hash": {i.NormalizedHash}}
+		}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			// 以前のテストで保存したリサイズ画像と重ならないように、元画像の URL を毎回変える
+			prefix := fmt.Sprintf("%s/f-png24.png?purge=%s&", fixturesServer.URL, c.name)
+			u := fmt.Sprintf("%st=%d", prefix, time.Now().UnixNano())
+			resize(t, u)
+			saved := waitSaved(t, u)
+
+			r := requestPurge(t, c.form(prefix, u, saved))
+			found := false
+			for _, i := range r.Images {
+				if i.ID == saved.ID && i.Filename == saved.Filename && i.URL == u {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("purged images should contain %+v, but got %+v", saved, r.Images)
+			}
+			if r := requestPurge(t, url.Values{"url": {u}, "dry_run": {"true"}}); len(r.Images) != 0 {
+				t.Errorf("records should be deleted, but got %+v", r.Images)
+			}
+
+			// 削除した後のリクエストはリダイレクトされずに再びリサイズされる
+			resize(t, u)
+			if resaved := waitSaved(t, u); resaved.ID == saved.ID {
+				t.Errorf("resized image should be saved again, but got %+v", resaved)
+			}
+		})
+	}
+}
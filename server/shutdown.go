@@ -0,0 +1,39 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/minodisk/resizer/logger"
+)
+
+// traceShutdownTimeout は終了時に記録したスパンを送信する際のタイムアウト。
+const traceShutdownTimeout = 5 * time.Second
+
+// shutdown はサーバー s の新しい接続の受け付けを止めて処理中のリクエストの完了を待ち、
+// 続けてリサイズ画像の保存の完了を待つ。ctx が終了しても完了していない保存はキューに残し、
+// 次に起動した時に再開する。
+// 最後にメトリクスのサーバー m を止め、記録したスパンを送信する。
+func (h *Handler) shutdown(ctx context.Context, s, m *http.Server) {
+	l := logger.Default()
+	if err := s.Shutdown(ctx); err != nil {
+		l.Warn("fail to finish requests", "err", err)
+	}
+	if n := h.Saves.Close(ctx); n > 0 {
+		l.Warn("saves are left in queue", "count", n)
+	} else {
+		l.Info("drained saves")
+	}
+	if m != nil {
+		if err := m.Close(); err != nil {
+			l.Warn("fail to stop serving metrics", "err", err)
+		}
+	}
+	// 保存を待って ctx が終了していてもスパンを送信できるよう、ctx とは別に待つ
+	tctx, cancel := context.WithTimeout(context.Background(), traceShutdownTimeout)
+	defer cancel()
+	if err := h.Tracer.Shutdown(tctx); err != nil {
+		l.Warn("fail to flush spans", "err", err)
+	}
+}
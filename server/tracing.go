@@ -0,0 +1,30 @@
+package server
+
+import (
+	"os"
+
+	"github.com/minodisk/resizer/logger"
+	"github.com/minodisk/resizer/options"
+	"github.com/minodisk/resizer/trace"
+)
+
+const (
+	headerTraceparent = "traceparent"
+	serviceName       = "resizer"
+)
+
+// newTracer はオプション o で指定された Exporter にスパンを出力する Tracer を作成する。
+// Exporter が指定されていない場合はスパンを記録しない nil を返す。
+func newTracer(o *options.Options) *trace.Tracer {
+	switch o.TraceExporter {
+	case options.TraceExporterStdout:
+		return trace.New(trace.NewWriterExporter(os.Stdout))
+	case options.TraceExporterOTLP:
+		e := trace.NewOTLPExporter(o.TraceEndpoint, serviceName)
+		e.OnError = func(err error) {
+			logger.Default().Warn("fail to export spans", "err", err)
+		}
+		return trace.New(e)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/minodisk/resizer/options"
+	"github.com/minodisk/resizer/signature"
+	"github.com/pkg/errors"
+)
+
+// sign は引数で指定されたリクエストURLに署名を付加して出力する。
+//
+//   $ resizer sign -key secret -expires 24h 'https://resizer.example.com/?url=...&width=100'
+func sign(args []string) error {
+	var keys options.Keys
+	keys.Set(os.Getenv(options.EnvSigningKey))
+	defaultKey := ""
+	if len(keys) > 0 {
+		defaultKey = keys[0]
+	}
+
+	fs := flag.NewFlagSet("resizer sign", flag.ContinueOnError)
+	key := fs.String("key", defaultKey, `Secret key to sign the URL.
+         In default, the first key in `+options.EnvSigningKey+` is used.`)
+	expires := fs.Duration("expires", 0, `Duration until the signed URL expires.
+         When 0 is specified, the signed URL doesn't expire.`)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: resizer sign [options] URL...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *key == "" {
+		return errors.New("key to sign isn't specified")
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("URL to sign isn't specified")
+	}
+
+	var e time.Time
+	if *expires > 0 {
+		e = time.Now().Add(*expires)
+	}
+	for _, u := range fs.Args() {
+		signed, err := signature.SignURL([]byte(strings.TrimSpace(*key)), u, e)
+		if err != nil {
+			return err
+		}
+		fmt.Println(signed)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package signature
+
+import (
+	"fmt"
+	"time"
+)
+
+type MissingSignatureError struct{}
+
+func NewMissingSignatureError() MissingSignatureError {
+	return MissingSignatureError{}
+}
+
+func (err MissingSignatureError) Error() string {
+	return "signature is required"
+}
+
+type InvalidSignatureError struct{}
+
+func NewInvalidSignatureError() InvalidSignatureError {
+	return InvalidSignatureError{}
+}
+
+func (err InvalidSignatureError) Error() string {
+	return "signature is invalid"
+}
+
+type ExpiredError struct {
+	Expires time.Time
+}
+
+func NewExpiredError(expires time.Time) ExpiredError {
+	return ExpiredError{expires}
+}
+
+func (err ExpiredError) Error() string {
+	return fmt.Sprintf("signed URL expired at %s", err.Expires.UTC().Format(time.RFC3339))
+}
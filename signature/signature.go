@@ -0,0 +1,91 @@
+// Package signature では HMAC によるリクエストURLの署名が実装されています。
+//
+// 署名はパスと、signature を除いたクエリをキーでソートして
+// エンコードした文字列に対する HMAC-SHA256 を base64url でエンコードしたものです。
+// expires が含まれる場合、その UNIX 時間を過ぎた URL は無効になります。
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	KeySignature = "signature"
+	KeyExpires   = "expires"
+)
+
+// Sign はパス path とクエリ q に対する署名をキー key で作成する。
+// q に含まれる signature は無視される。
+func Sign(key []byte, path string, q url.Values) string {
+	c := url.Values{}
+	for k, v := range q {
+		if k == KeySignature {
+			continue
+		}
+		c[k] = v
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(path + "?" + c.Encode()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SignURL は URL rawurl に署名を付加した URL を返す。
+// expires がゼロ値でなければ有効期限として付加する。
+func SignURL(key []byte, rawurl string, expires time.Time) (string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", errors.Wrap(err, "fail to parse URL")
+	}
+	q := u.Query()
+	q.Del(KeySignature)
+	if expires.IsZero() {
+		q.Del(KeyExpires)
+	} else {
+		q.Set(KeyExpires, strconv.FormatInt(expires.Unix(), 10))
+	}
+	q.Set(KeySignature, Sign(key, u.EscapedPath(), q))
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Verify はパス path とクエリ q の署名がキー keys のいずれかで作成されたものか、
+// また有効期限が時刻 now を過ぎていないかを検証する。
+func Verify(keys [][]byte, path string, q url.Values, now time.Time) error {
+	sig := q.Get(KeySignature)
+	if sig == "" {
+		return NewMissingSignatureError()
+	}
+	given, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return NewInvalidSignatureError()
+	}
+	valid := false
+	for _, key := range keys {
+		want, _ := base64.RawURLEncoding.DecodeString(Sign(key, path, q))
+		if hmac.Equal(given, want) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return NewInvalidSignatureError()
+	}
+	if e := q.Get(KeyExpires); e != "" {
+		sec, err := strconv.ParseInt(e, 10, 64)
+		if err != nil {
+			return NewInvalidSignatureError()
+		}
+		expires := time.Unix(sec, 0)
+		if now.After(expires) {
+			return NewExpiredError(expires)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package signature_test
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/minodisk/resizer/signature"
+)
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1500000000, 0)
+	keys := [][]byte{
+		[]byte("new"),
+		[]byte("old"),
+	}
+	for _, c := range []struct {
+		name    string
+		key     []byte
+		expires time.Time
+		modify  func(q url.Values)
+		err     error
+	}{
+		{
+			"valid",
+			[]byte("new"),
+			time.Time{},
+			nil,
+			nil,
+		},
+		{
+			"valid with old key",
+			[]byte("old"),
+			time.Time{},
+			nil,
+			nil,
+		},
+		{
+			"valid with expires",
+			[]byte("new"),
+			now.Add(time.Hour),
+			nil,
+			nil,
+		},
+		{
+			"unknown key",
+			[]byte("unknown"),
+			time.Time{},
+			nil,
+			signature.NewInvalidSignatureError(),
+		},
+		{
+			"tampered",
+			[]byte("new"),
+			time.Time{},
+			func(q url.Values) {
+				q.Set("width", "10000")
+			},
+			signature.NewInvalidSignatureError(),
+		},
+		{
+			"missing",
+			[]byte("new"),
+			time.Time{},
+			func(q url.Values) {
+				q.Del(signature.KeySignature)
+			},
+			signature.NewMissingSignatureError(),
+		},
+		{
+			"expired",
+			[]byte("new"),
+			now.Add(-time.Second),
+			nil,
+			signature.NewExpiredError(now.Add(-time.Second)),
+		},
+	} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			signed, err := signature.SignURL(c.key, "http://resizer.example.com/?url=http%3A%2F%2Fexample.com%2Fa.jpg&width=100", c.expires)
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, err := url.Parse(signed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			q := u.Query()
+			if c.modify != nil {
+				c.modify(q)
+			}
+			err = signature.Verify(keys, u.EscapedPath(), q, now)
+			if !reflect.DeepEqual(err, c.err) {
+				t.Errorf("error\n got: %+v\nwant: %+v", err, c.err)
+			}
+		})
+	}
+}
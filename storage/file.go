@@ -34,6 +34,9 @@ type Image struct {
 	ContentType      string `sql:"size:80"`
 	ETag             string `sql:"size:32"`
 	Filename         string
+	// Origin は元画像の URL を解決した取得先の名前。保存はしない。
+	// 取得先から解決した元画像は内部向けのアドレスから取得しうるため、ハッシュには含める。
+	Origin string `sql:"-"`
 }
 
 // New はクエリのマップ q から File を作成する。
@@ -46,6 +49,7 @@ func NewImage(input input.Input) (Image, error) {
 		ValidatedHeight:  input.Height,
 		ValidatedFormat:  input.Format,
 		ValidatedQuality: input.Quality,
+		Origin:           input.Origin,
 	}.serializeValidatedProps()
 }
 
@@ -59,6 +63,7 @@ func (i Image) serializeValidatedProps() (Image, error) {
 		ValidatedQuality: i.ValidatedQuality,
 		ValidatedWidth:   i.ValidatedWidth,
 		ValidatedHeight:  i.ValidatedHeight,
+		Origin:           i.Origin,
 	}); err != nil {
 		return i, err
 	}
@@ -177,6 +182,7 @@ func (i Image) serializeNormalizedProps() (Image, error) {
 		ValidatedQuality: i.ValidatedQuality,
 		DestWidth:        i.DestWidth,
 		DestHeight:       i.DestHeight,
+		Origin:           i.Origin,
 	}); err != nil {
 		return i, err
 	}
@@ -185,13 +191,23 @@ func (i Image) serializeNormalizedProps() (Image, error) {
 	return i, nil
 }
 
+// CreateFilename はリサイズ画像を保存するオブジェクトの名前を作成する。
+// オプションで hash が指定されている場合は、正規化済みのオプションのハッシュから
+// 常に同じ名前を作成するので、同じリサイズ画像は同じオブジェクトに保存される。
 func (i Image) CreateFilename(o *options.Options) string {
-	id := uuid.NewV4().String()
 	ext, ok := map[string]string{
 		"jpeg": "jpg",
 	}[i.ValidatedFormat]
 	if !ok {
 		ext = i.ValidatedFormat
 	}
-	return fmt.Sprintf("%s%s.%s", o.ObjectPrefix, id, ext)
+	if o.Naming != options.NamingHash {
+		id := uuid.NewV4().String()
+		return fmt.Sprintf("%s%s.%s", o.ObjectPrefix, id, ext)
+	}
+	dir := ""
+	for d := 0; d < o.ShardDepth && len(i.NormalizedHash) >= (d+1)*2; d++ {
+		dir += i.NormalizedHash[d*2:(d+1)*2] + "/"
+	}
+	return fmt.Sprintf("%s%s%s.%s", o.ObjectPrefix, dir, i.NormalizedHash, ext)
 }
@@ -1,5 +1,15 @@
 package storage_test
 
+import (
+	"image"
+	"regexp"
+	"testing"
+
+	"github.com/minodisk/resizer/input"
+	"github.com/minodisk/resizer/options"
+	"github.com/minodisk/resizer/storage"
+)
+
 // func TestSerialization(t *testing.T) {
 // 	o, err := option.New(os.Args[1:])
 // 	if err != nil {
@@ -312,3 +322,88 @@ package storage_test
 // 		}
 // 	}
 // }
+
+func TestHashWithOrigin(t *testing.T) {
+	t.Parallel()
+	in := input.Input{
+		URL:    "https://a.com/images/a.jpg",
+		Method: input.MethodDefault,
+		Width:  100,
+		Format: "jpeg",
+	}
+	i, err := storage.NewImage(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in.Origin = "images"
+	o, err := storage.NewImage(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.ValidatedHash == o.ValidatedHash {
+		t.Errorf("validated hash should be different with origin: %s", i.ValidatedHash)
+	}
+	size := image.Pt(200, 100)
+	if i, err = i.Normalize(size); err != nil {
+		t.Fatal(err)
+	}
+	if o, err = o.Normalize(size); err != nil {
+		t.Fatal(err)
+	}
+	if i.NormalizedHash == o.NormalizedHash {
+		t.Errorf("normalized hash should be different with origin: %s", i.NormalizedHash)
+	}
+}
+
+func TestCreateFilename(t *testing.T) {
+	t.Parallel()
+	i := storage.Image{
+		ValidatedFormat: "jpeg",
+		NormalizedHash:  "0123456789abcdef0123456789abcdef",
+	}
+	for _, c := range []struct {
+		name    string
+		options options.Options
+		want    *regexp.Regexp
+	}{
+		{
+			"random",
+			options.Options{
+				ObjectPrefix: "foo/",
+				Naming:       options.NamingRandom,
+			},
+			regexp.MustCompile(`^foo/[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\.jpg$`),
+		},
+		{
+			"hash",
+			options.Options{
+				ObjectPrefix: "foo/",
+				Naming:       options.NamingHash,
+			},
+			regexp.MustCompile(`^foo/0123456789abcdef0123456789abcdef\.jpg$`),
+		},
+		{
+			"hash with shard",
+			options.Options{
+				ObjectPrefix: "foo/",
+				Naming:       options.NamingHash,
+				ShardDepth:   2,
+			},
+			regexp.MustCompile(`^foo/01/23/0123456789abcdef0123456789abcdef\.jpg$`),
+		},
+	} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			got := i.CreateFilename(&c.options)
+			if !c.want.MatchString(got) {
+				t.Errorf("got: %s, want: %s", got, c.want)
+			}
+			if c.options.Naming == options.NamingHash {
+				if again := i.CreateFilename(&c.options); again != got {
+					t.Errorf("should be deterministic: %s != %s", again, got)
+				}
+			}
+		})
+	}
+}
@@ -1,20 +1,34 @@
 package storage
 
 import (
-	"log"
+	"context"
+	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jinzhu/gorm"
+	"github.com/minodisk/resizer/logger"
 	"github.com/minodisk/resizer/options"
-	"github.com/pkg/errors"
 )
 
-type Logger struct{}
+// Logger は gorm のログを l に出力する。
+type Logger struct {
+	l *logger.Logger
+}
 
+// Print は gorm から渡された値を出力する。
+// SQL のログは "sql", 呼び出し元, 実行時間, SQL, パラメーターの順で渡される。
 func (l Logger) Print(values ...interface{}) {
-	log.Println(values...)
+	if len(values) == 5 && values[0] == "sql" {
+		l.l.Debug("execute sql",
+			"source", values[1], "duration", values[2],
+			"sql", values[3], "vars", fmt.Sprint(values[4]),
+		)
+		return
+	}
+	l.l.Debug("gorm", "values", fmt.Sprint(values...))
 }
 
 type Storage struct {
@@ -29,12 +43,11 @@ func New(o *options.Options) (*Storage, error) {
 		if err == nil {
 			break
 		}
-		log.Println(errors.Wrap(err, "wait for connection"))
+		logger.Default().Warn("wait for connection", "err", err)
 		time.Sleep(time.Second)
 	}
-	db.LogMode(false)
-	// db.LogMode(true)
-	// db.SetLogger(&Logger{})
+	db.LogMode(o.Verbose)
+	db.SetLogger(Logger{logger.Default()})
 	if os.Getenv("ENVIRONMENT") == "development" {
 		db.DropTable(&Image{})
 	}
@@ -46,13 +59,92 @@ func New(o *options.Options) (*Storage, error) {
 		if err == nil {
 			break
 		}
-		log.Println(errors.Wrap(err, "wait for communication"))
+		logger.Default().Warn("wait for communication", "err", err)
 		time.Sleep(time.Second)
 	}
 
 	return &Storage{db}, nil
 }
 
+// WithContext は Context ctx の Logger に SQL のログを出力する Storage を返す。
+func (self *Storage) WithContext(ctx context.Context) *Storage {
+	db := self.DB.New()
+	db.SetLogger(Logger{logger.FromContext(ctx)})
+	return &Storage{db}
+}
+
+// FindByValidated はバリデート済みのオプションが i と一致するリサイズ画像のレコードを探す。
+func (self *Storage) FindByValidated(i Image) (Image, bool) {
+	cache := Image{}
+	self.Where(&Image{
+		ValidatedHash:    i.ValidatedHash,
+		ValidatedWidth:   i.ValidatedWidth,
+		ValidatedHeight:  i.ValidatedHeight,
+		ValidatedMethod:  i.ValidatedMethod,
+		ValidatedFormat:  i.ValidatedFormat,
+		ValidatedQuality: i.ValidatedQuality,
+	}).First(&cache)
+	return cache, cache.ID != 0
+}
+
+// FindByNormalized は正規化済みのオプションが i と一致するリサイズ画像のレコードを探す。
+func (self *Storage) FindByNormalized(i Image) (Image, bool) {
+	cache := Image{}
+	self.Where(&Image{
+		NormalizedHash:   i.NormalizedHash,
+		DestWidth:        i.DestWidth,
+		DestHeight:       i.DestHeight,
+		ValidatedMethod:  i.ValidatedMethod,
+		ValidatedFormat:  i.ValidatedFormat,
+		ValidatedQuality: i.ValidatedQuality,
+	}).First(&cache)
+	return cache, cache.ID != 0
+}
+
+// FindByURL は元画像の URL が url のリサイズ画像のレコードを探す。
+func (self *Storage) FindByURL(url string) ([]Image, error) {
+	images := []Image{}
+	if err := self.Where("validated_url = ?", url).Find(&images).Error; err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// FindByURLPrefix は元画像の URL が prefix から始まるリサイズ画像のレコードを探す。
+func (self *Storage) FindByURLPrefix(prefix string) ([]Image, error) {
+	images := []Image{}
+	if err := self.Where("validated_url LIKE ?", likeEscaper.Replace(prefix)+"%").Find(&images).Error; err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// FindByNormalizedHash は正規化済みのオプションのハッシュが hash のリサイズ画像のレコードを探す。
+func (self *Storage) FindByNormalizedHash(hash string) ([]Image, error) {
+	images := []Image{}
+	if err := self.Where(&Image{NormalizedHash: hash}).Find(&images).Error; err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// Delete はリサイズ画像 i のレコードを削除する。
+func (self *Storage) Delete(i Image) error {
+	// ID がない場合に全てのレコードを削除しないようにする
+	if i.ID == 0 {
+		return fmt.Errorf("can't delete image without ID: %s", i.Filename)
+	}
+	return self.DB.Delete(&Image{ID: i.ID}).Error
+}
+
+// likeEscaper は LIKE のパターンで特別な意味を持つ文字をエスケープする。
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// Ping はデータベースに接続できるかを調べる。
+func (self *Storage) Ping(ctx context.Context) error {
+	return self.DB.DB().PingContext(ctx)
+}
+
 func (self *Storage) Close() error {
 	return self.DB.DB().Close()
 }
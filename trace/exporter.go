@@ -0,0 +1,291 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WriterExporter はスパンを 1 行ずつ JSON で w に出力する。
+type WriterExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterExporter は w にスパンを出力する WriterExporter を作成する。
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{w: w}
+}
+
+type writerSpan struct {
+	TraceID      string                 `json:"trace_id"`
+	SpanID       string                 `json:"span_id"`
+	ParentSpanID string                 `json:"parent_span_id,omitempty"`
+	Name         string                 `json:"name"`
+	Start        time.Time              `json:"start"`
+	End          time.Time              `json:"end"`
+	Duration     string                 `json:"duration"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	Error        string                 `json:"error,omitempty"`
+}
+
+// ExportSpan はスパン s を出力する。
+func (e *WriterExporter) ExportSpan(s SpanData) {
+	ws := writerSpan{
+		TraceID:  s.TraceID.String(),
+		SpanID:   s.SpanID.String(),
+		Name:     s.Name,
+		Start:    s.Start,
+		End:      s.End,
+		Duration: s.End.Sub(s.Start).String(),
+		Error:    s.Error,
+	}
+	if s.ParentSpanID.IsValid() {
+		ws.ParentSpanID = s.ParentSpanID.String()
+	}
+	if len(s.Attributes) > 0 {
+		ws.Attributes = make(map[string]interface{}, len(s.Attributes))
+		for _, a := range s.Attributes {
+			ws.Attributes[a.Key] = a.Value
+		}
+	}
+	b, err := json.Marshal(ws)
+	if err != nil {
+		return
+	}
+	e.mu.Lock()
+	e.w.Write(append(b, '\n'))
+	e.mu.Unlock()
+}
+
+// Shutdown は何もしない。
+func (e *WriterExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+const (
+	otlpTracesPath = "/v1/traces"
+	otlpBatchSize  = 512
+	otlpQueueSize  = 4096
+	otlpInterval   = 5 * time.Second
+)
+
+// OTLPExporter はスパンをまとめて OTLP/HTTP の JSON でコレクターに送信する。
+// 送信が追いつかない場合、キューに入りきらないスパンは破棄する。
+type OTLPExporter struct {
+	url         string
+	serviceName string
+	client      *http.Client
+	spans       chan SpanData
+	done        chan struct{}
+	// mu は spans を閉じる間、スパンをキューに入れないようにする。
+	mu     sync.RWMutex
+	closed bool
+	// OnError は送信に失敗した時に呼ばれる。
+	OnError func(error)
+}
+
+// NewOTLPExporter はエンドポイント endpoint のコレクターにサービス名 serviceName のスパンを送信する
+// OTLPExporter を作成する。endpoint のパスが空の場合は /v1/traces に送信する。
+func NewOTLPExporter(endpoint, serviceName string) *OTLPExporter {
+	u := strings.TrimSuffix(endpoint, "/")
+	if i := strings.Index(u, "://"); i < 0 || !strings.Contains(u[i+3:], "/") {
+		u += otlpTracesPath
+	}
+	e := &OTLPExporter{
+		url:         u,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		spans:       make(chan SpanData, otlpQueueSize),
+		done:        make(chan struct{}),
+		OnError:     func(error) {},
+	}
+	go e.run()
+	return e
+}
+
+// ExportSpan はスパン s を送信するキューに入れる。
+// Shutdown の後に終了したスパンは破棄する。
+func (e *OTLPExporter) ExportSpan(s SpanData) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		return
+	}
+	select {
+	case e.spans <- s:
+	default:
+	}
+}
+
+// Shutdown はキューに残っているスパンを送信して終了する。
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.spans)
+	}
+	e.mu.Unlock()
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *OTLPExporter) run() {
+	defer close(e.done)
+	t := time.NewTicker(otlpInterval)
+	defer t.Stop()
+	batch := make([]SpanData, 0, otlpBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(batch); err != nil {
+			e.OnError(err)
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case s, ok := <-e.spans:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) >= otlpBatchSize {
+				flush()
+			}
+		case <-t.C:
+			flush()
+		}
+	}
+}
+
+func (e *OTLPExporter) send(spans []SpanData) error {
+	b, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("fail to export spans to %s: status code %d", e.url, resp.StatusCode)
+	}
+	return nil
+}
+
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string                 `json:"key"`
+		Value map[string]interface{} `json:"value"`
+	}
+)
+
+// otlpStatusError は OTLP のエラーを表すステータスコード。
+const otlpStatusError = 2
+
+func (e *OTLPExporter) request(spans []SpanData) otlpRequest {
+	ss := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		o := otlpSpan{
+			TraceID:           s.TraceID.String(),
+			SpanID:            s.SpanID.String(),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		}
+		if s.ParentSpanID.IsValid() {
+			o.ParentSpanID = s.ParentSpanID.String()
+		}
+		for _, a := range s.Attributes {
+			o.Attributes = append(o.Attributes, otlpAttribute(a.Key, a.Value))
+		}
+		if s.Error != "" {
+			o.Status = otlpStatus{Code: otlpStatusError, Message: s.Error}
+		}
+		ss[i] = o
+	}
+	return otlpRequest{
+		ResourceSpans: []otlpResourceSpans{
+			{
+				Resource: otlpResource{
+					Attributes: []otlpKeyValue{otlpAttribute("service.name", e.serviceName)},
+				},
+				ScopeSpans: []otlpScopeSpans{
+					{
+						Scope: otlpScope{Name: e.serviceName},
+						Spans: ss,
+					},
+				},
+			},
+		},
+	}
+}
+
+func otlpAttribute(key string, v interface{}) otlpKeyValue {
+	var value map[string]interface{}
+	switch v := v.(type) {
+	case string:
+		value = map[string]interface{}{"stringValue": v}
+	case bool:
+		value = map[string]interface{}{"boolValue": v}
+	case int:
+		value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+	case int64:
+		value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		value = map[string]interface{}{"doubleValue": v}
+	default:
+		value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+	}
+	return otlpKeyValue{key, value}
+}
@@ -0,0 +1,258 @@
+// Package trace では処理の区間 (スパン) を記録するトレーシングが実装されています。
+//
+// スパンは context.Context を通して親子関係を引き継ぎ、
+// 終了したスパンを Exporter に渡します。
+// リクエストの traceparent ヘッダーは W3C Trace Context の形式で解釈します。
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID はトレースを識別する ID。
+type TraceID [16]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid は ID が全てゼロでないかを返す。
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+// SpanID はスパンを識別する ID。
+type SpanID [8]byte
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid は ID が全てゼロでないかを返す。
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// SpanContext はプロセスをまたいで引き継ぐスパンの識別子。
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid はトレースとスパンの ID が有効かを返す。
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent は traceparent ヘッダーの値を返す。
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent は traceparent ヘッダーの値 s を解釈する。
+//
+//   00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, fmt.Errorf("invalid traceparent '%s'", s)
+	}
+	// 未知のバージョンは 4 つ目より後のフィールドを無視して解釈する
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, fmt.Errorf("invalid traceparent '%s'", s)
+	}
+	if err := decodeHex(sc.TraceID[:], parts[1]); err != nil {
+		return sc, fmt.Errorf("invalid trace ID in traceparent '%s'", s)
+	}
+	if err := decodeHex(sc.SpanID[:], parts[2]); err != nil {
+		return sc, fmt.Errorf("invalid parent ID in traceparent '%s'", s)
+	}
+	var flags [1]byte
+	if err := decodeHex(flags[:], parts[3]); err != nil {
+		return sc, fmt.Errorf("invalid flags in traceparent '%s'", s)
+	}
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("invalid traceparent '%s'", s)
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+// decodeHex は小文字の 16 進数の文字列 s を dst にデコードする。
+func decodeHex(dst []byte, s string) error {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return fmt.Errorf("invalid length or case")
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
+
+// Exporter は終了したスパンを出力する。
+type Exporter interface {
+	// ExportSpan はスパン s を出力する。複数の goroutine から同時に呼ばれる。
+	ExportSpan(s SpanData)
+	// Shutdown は出力していないスパンを出力して終了する。
+	Shutdown(ctx context.Context) error
+}
+
+// Tracer はスパンを作成し、終了したスパンを Exporter に渡す。
+// nil の Tracer はスパンを記録しない。
+type Tracer struct {
+	exporter Exporter
+}
+
+// New は終了したスパンを e に渡す Tracer を作成する。
+func New(e Exporter) *Tracer {
+	return &Tracer{exporter: e}
+}
+
+// Shutdown は Exporter を終了する。
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	return t.exporter.Shutdown(ctx)
+}
+
+// StartRoot はリモートの親 parent を持つスパンを開始する。
+// parent が有効でない場合は新しいトレースを開始する。
+// リモートの親が記録しないと決めたトレースでは、識別子だけを引き継ぐ記録しないスパンを返す。
+func (t *Tracer) StartRoot(ctx context.Context, name string, parent SpanContext) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := &Span{tracer: t, sampled: !parent.IsValid() || parent.Sampled}
+	s.data.Name = name
+	s.data.Kind = KindServer
+	s.data.Start = time.Now()
+	if parent.IsValid() {
+		s.data.TraceID = parent.TraceID
+		s.data.ParentSpanID = parent.SpanID
+	} else {
+		rand.Read(s.data.TraceID[:])
+	}
+	rand.Read(s.data.SpanID[:])
+	return context.WithValue(ctx, contextKey{}, s), s
+}
+
+type contextKey struct{}
+
+// FromContext は ctx のスパンを返す。スパンがない場合は nil を返す。
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(contextKey{}).(*Span)
+	return s
+}
+
+// Start は ctx のスパンの子のスパンを開始する。
+// ctx にスパンがない場合は記録しない nil のスパンを返す。
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	parent := FromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	s := &Span{tracer: parent.tracer, sampled: parent.sampled}
+	s.data.Name = name
+	s.data.Kind = KindInternal
+	s.data.Start = time.Now()
+	s.data.TraceID = parent.data.TraceID
+	s.data.ParentSpanID = parent.data.SpanID
+	rand.Read(s.data.SpanID[:])
+	return context.WithValue(ctx, contextKey{}, s), s
+}
+
+// スパンの種類。
+const (
+	KindInternal = 1
+	KindServer   = 2
+)
+
+// SpanData は終了したスパンの内容。
+type SpanData struct {
+	TraceID      TraceID
+	SpanID       SpanID
+	ParentSpanID SpanID
+	Name         string
+	Kind         int
+	Start        time.Time
+	End          time.Time
+	// Attributes は属性のキーと値の組。
+	Attributes []Attribute
+	// Error はスパンの処理が失敗した場合のエラーメッセージ。
+	Error string
+}
+
+// Attribute はスパンの属性。
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Span は処理の区間を記録する。
+// nil の Span のメソッドは何もしない。
+type Span struct {
+	tracer *Tracer
+	// sampled が false のスパンは識別子を子のスパンやリクエストに引き継ぐだけで、記録しない。
+	sampled bool
+	mu      sync.Mutex
+	data    SpanData
+	ended   bool
+}
+
+// SpanContext はスパンの識別子を返す。
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return SpanContext{TraceID: s.data.TraceID, SpanID: s.data.SpanID, Sampled: s.sampled}
+}
+
+// SetAttributes はキーと値の組 kv を属性に加える。
+func (s *Span) SetAttributes(kv ...interface{}) {
+	if s == nil || !s.sampled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i+1 < len(kv); i += 2 {
+		s.data.Attributes = append(s.data.Attributes, Attribute{fmt.Sprint(kv[i]), kv[i+1]})
+	}
+}
+
+// RecordError は処理がエラー err で失敗したことを記録する。err が nil の場合は何もしない。
+func (s *Span) RecordError(err error) {
+	if s == nil || !s.sampled || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Error = err.Error()
+	s.mu.Unlock()
+}
+
+// End はスパンを終了して Exporter に渡す。2 回目以降の呼び出しは何もしない。
+func (s *Span) End() {
+	if s == nil || !s.sampled {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	d := s.data
+	s.mu.Unlock()
+	s.tracer.exporter.ExportSpan(d)
+}
@@ -0,0 +1,215 @@
+package trace_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/minodisk/resizer/trace"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	t.Parallel()
+
+	for _, c := range []struct {
+		value   string
+		wantErr bool
+		sampled bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", false, false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", false, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", true, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", true, false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", true, false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01", true, false},
+		{"", true, false},
+	} {
+		sc, err := trace.ParseTraceparent(c.value)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("%q should be invalid", c.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", c.value, err)
+			continue
+		}
+		if sc.Sampled != c.sampled {
+			t.Errorf("%q: sampled should be %t", c.value, c.sampled)
+		}
+		if got, want := sc.Traceparent(), "00"+c.value[2:55]; got != want {
+			t.Errorf("%q: formatted as %q", c.value, got)
+		}
+	}
+}
+
+func TestSpans(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	tr := trace.New(trace.NewWriterExporter(&buf))
+	parent, _ := trace.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, root := tr.StartRoot(context.Background(), "request", parent)
+	_, child := trace.Start(ctx, "fetch")
+	child.SetAttributes("url", "http://a.com/a.png")
+	child.RecordError(errors.New("timeout"))
+	child.End()
+	root.End()
+	root.End()
+
+	var spans []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var s map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &s); err != nil {
+			t.Fatal(err)
+		}
+		spans = append(spans, s)
+	}
+	if len(spans) != 2 {
+		t.Fatalf("2 spans should be exported, but got %d", len(spans))
+	}
+	if spans[0]["name"] != "fetch" || spans[1]["name"] != "request" {
+		t.Errorf("wrong order of spans: %v", spans)
+	}
+	for _, s := range spans {
+		if s["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("trace ID should be inherited, but got %v", s["trace_id"])
+		}
+	}
+	if spans[1]["parent_span_id"] != "00f067aa0ba902b7" {
+		t.Errorf("root span should have the remote parent, but got %v", spans[1]["parent_span_id"])
+	}
+	if spans[0]["parent_span_id"] != spans[1]["span_id"] {
+		t.Errorf("child span should have the root span as parent")
+	}
+	if spans[0]["error"] != "timeout" || spans[0]["attributes"].(map[string]interface{})["url"] != "http://a.com/a.png" {
+		t.Errorf("wrong child span: %v", spans[0])
+	}
+}
+
+func TestNotSampled(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	tr := trace.New(trace.NewWriterExporter(&buf))
+	parent, _ := trace.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	ctx, root := tr.StartRoot(context.Background(), "request", parent)
+	_, child := trace.Start(ctx, "fetch")
+	child.SetAttributes("url", "http://a.com/a.png")
+	child.End()
+	root.End()
+	// 記録しない場合もトレースを引き継ぐ
+	if sc := child.SpanContext(); sc.TraceID != parent.TraceID || !sc.SpanID.IsValid() || sc.Sampled {
+		t.Errorf("child should propagate the trace without sampling: %+v", sc)
+	}
+	if sc := root.SpanContext(); sc.TraceID != parent.TraceID || sc.SpanID == parent.SpanID || sc.Sampled {
+		t.Errorf("root should propagate the trace without sampling: %+v", sc)
+	}
+
+	var nilTracer *trace.Tracer
+	ctx, root = nilTracer.StartRoot(context.Background(), "request", trace.SpanContext{})
+	_, child = trace.Start(ctx, "fetch")
+	child.End()
+	root.End()
+
+	if buf.Len() != 0 {
+		t.Errorf("spans shouldn't be exported: %s", buf.String())
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	t.Parallel()
+
+	reqs := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request: %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		reqs <- b
+	}))
+	defer server.Close()
+
+	e := trace.NewOTLPExporter(server.URL, "resizer")
+	tr := trace.New(e)
+	ctx, root := tr.StartRoot(context.Background(), "request", trace.SpanContext{})
+	_, child := trace.Start(ctx, "resize")
+	child.SetAttributes("width", 100)
+	child.RecordError(errors.New("fail"))
+	child.End()
+	root.End()
+	if err := tr.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID      string `json:"traceId"`
+					SpanID       string `json:"spanId"`
+					ParentSpanID string `json:"parentSpanId"`
+					Name         string `json:"name"`
+					Attributes   []struct {
+						Key   string
+						Value map[string]interface{}
+					}
+					Status struct {
+						Code    int
+						Message string
+					}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(<-reqs, &got); err != nil {
+		t.Fatal(err)
+	}
+	spans := got.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("2 spans should be sent, but got %d", len(spans))
+	}
+	resize, request := spans[0], spans[1]
+	if resize.Name != "resize" || request.Name != "request" {
+		t.Errorf("wrong spans: %+v", spans)
+	}
+	if resize.TraceID != request.TraceID || resize.ParentSpanID != request.SpanID || len(resize.TraceID) != 32 {
+		t.Errorf("wrong IDs: %+v", spans)
+	}
+	if resize.Status.Code != 2 || resize.Status.Message != "fail" {
+		t.Errorf("wrong status: %+v", resize.Status)
+	}
+	if a := resize.Attributes; len(a) != 1 || a[0].Key != "width" || a[0].Value["intValue"] != "100" {
+		t.Errorf("wrong attributes: %+v", a)
+	}
+}
+
+func TestOTLPExporterAfterShutdown(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	e := trace.NewOTLPExporter(server.URL, "resizer")
+	tr := trace.New(e)
+	// 終了を待つ間に処理中のリクエストのスパンが終了する場合がある
+	_, span := tr.StartRoot(context.Background(), "request", trace.SpanContext{})
+	if err := tr.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	span.End()
+	e.ExportSpan(trace.SpanData{Name: "late"})
+	if err := e.Shutdown(context.Background()); err != nil {
+		t.Errorf("fail to Shutdown twice: %v", err)
+	}
+}
@@ -2,29 +2,30 @@ package uploader
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
-	"log"
+	"time"
 
 	gcs "cloud.google.com/go/storage"
 
 	opt "google.golang.org/api/option"
 
+	"github.com/minodisk/resizer/logger"
 	"github.com/minodisk/resizer/options"
 	"github.com/minodisk/resizer/storage"
 	"github.com/pkg/errors"
-	"golang.org/x/net/context"
 )
 
 const (
-	scope     = gcs.ScopeFullControl
-	sixMonths = 60 * 60 * 24 * 30 * 6
+	scope = gcs.ScopeFullControl
 )
 
 type Uploader struct {
 	context    context.Context
 	bucket     *gcs.BucketHandle
 	bucketName string
+	maxAge     time.Duration
 }
 
 // New はアップローダーを作成する。
@@ -38,9 +39,18 @@ func New(o *options.Options) (*Uploader, error) {
 		context:    ctx,
 		bucket:     client.Bucket(o.Bucket),
 		bucketName: o.Bucket,
+		maxAge:     o.CacheMaxAge,
 	}, nil
 }
 
+// WithContext は Context ctx で操作する Uploader を返す。
+// ログは ctx の Logger に出力する。
+func (u *Uploader) WithContext(ctx context.Context) *Uploader {
+	c := *u
+	c.context = ctx
+	return &c
+}
+
 func (u *Uploader) Upload(buf *bytes.Buffer, f storage.Image) (string, error) {
 	object := u.bucket.Object(f.Filename)
 	w := object.NewWriter(u.context)
@@ -52,22 +62,59 @@ func (u *Uploader) Upload(buf *bytes.Buffer, f storage.Image) (string, error) {
 		return "", errors.Wrap(err, "can't close object writer")
 	}
 
-	log.Printf("Write %d bytes object '%s' in bucket '%s'\n", written, f.Filename, u.bucketName)
+	l := logger.FromContext(u.context)
+	l.Debug("write object", "bucket", u.bucketName, "object", f.Filename, "bytes", written)
 
 	attrs, err := object.Update(u.context, gcs.ObjectAttrsToUpdate{
 		ContentType:  f.ContentType,
-		CacheControl: fmt.Sprintf("max-age=%d", sixMonths),
+		CacheControl: fmt.Sprintf("public, max-age=%d", int64(u.maxAge/time.Second)),
 	})
 	if err != nil {
 		return "", errors.Wrap(err, "can't update object attributes")
 	}
 
-	log.Printf("Attributes: %+v\n", *attrs)
+	l.Debug("update object attributes", "bucket", u.bucketName, "object", f.Filename,
+		"content_type", attrs.ContentType, "cache_control", attrs.CacheControl)
 
 	url := u.CreateURL(f.Filename)
 	return url, nil
 }
 
+// Exists はパス path のオブジェクトが存在するかを調べる。
+// 存在する場合はオブジェクトの属性を返す。
+func (u *Uploader) Exists(path string) (*gcs.ObjectAttrs, bool, error) {
+	attrs, err := u.bucket.Object(path).Attrs(u.context)
+	if err == gcs.ErrObjectNotExist {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, errors.Wrap(err, "can't get object attributes")
+	}
+	return attrs, true, nil
+}
+
+// Delete はパス path のオブジェクトを削除する。
+// オブジェクトが存在しない場合は何もしない。
+func (u *Uploader) Delete(path string) error {
+	err := u.bucket.Object(path).Delete(u.context)
+	if err == gcs.ErrObjectNotExist {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "can't delete object '%s'", path)
+	}
+	logger.FromContext(u.context).Debug("delete object", "bucket", u.bucketName, "object", path)
+	return nil
+}
+
+// Ping はバケットにアクセスできるかを調べる。
+func (u *Uploader) Ping(ctx context.Context) error {
+	if _, err := u.bucket.Attrs(ctx); err != nil {
+		return errors.Wrapf(err, "can't get attributes of bucket '%s'", u.bucketName)
+	}
+	return nil
+}
+
 func (u *Uploader) CreateURL(path string) string {
 	return fmt.Sprintf("https://%s.storage.googleapis.com/%s", u.bucketName, path)
 }
@@ -0,0 +1,35 @@
+// Package version ではビルドの情報を提供します。
+//
+// 値はリリースの際にリンカーのフラグで埋め込みます。
+//
+//   go build -ldflags "-X github.com/minodisk/resizer/version.Version=v1.0.0"
+package version
+
+import "runtime"
+
+var (
+	// Version はリリースのバージョン。
+	Version = "dev"
+	// Commit はビルドしたコミットのハッシュ。
+	Commit = "unknown"
+	// BuildDate はビルドした日時。
+	BuildDate = "unknown"
+)
+
+// Info はビルドの情報を表す。
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get はビルドの情報を返す。
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+}